@@ -0,0 +1,39 @@
+// Package adapter holds the types shared by the cache adapters and the
+// cache middleware client.
+package adapter
+
+import (
+	"log/slog"
+	"os"
+)
+
+// Stats describes the entries held by an adapter.
+type Stats struct {
+	// Entries is the number of cached entries.
+	Entries int
+
+	// Bytes is the total size of the cached entries, zero when the
+	// adapter cannot measure it.
+	Bytes int64
+
+	// Hits is the number of successful lookups since the adapter was
+	// created.
+	Hits uint64
+
+	// Misses is the number of failed lookups since the adapter was
+	// created.
+	Misses uint64
+}
+
+// Logger returns the logger of the named adapter: the given logger, or the
+// default logger when nil. When debug is set and no logger is given, debug
+// messages are written to stderr as before loggers could be set.
+func Logger(logger *slog.Logger, debug bool, name string) *slog.Logger {
+	if logger == nil {
+		logger = slog.Default()
+		if debug {
+			logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		}
+	}
+	return logger.With("adapter", name)
+}
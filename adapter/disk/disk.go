@@ -2,13 +2,18 @@ package disk
 
 import (
 	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"strconv"
+	"sync/atomic"
 	"time"
 
+	cacheadapter "github.com/coinpaprika/echo-http-cache/adapter"
 	"github.com/labstack/gommon/bytes"
-	"github.com/labstack/gommon/log"
 	"github.com/patrickmn/go-cache"
 	"github.com/peterbourgon/diskv"
 )
@@ -17,10 +22,16 @@ type (
 	Adapter struct {
 		directory     string
 		debug         bool
+		logger        *slog.Logger
 		db            *diskv.Diskv
 		maxMemorySize uint64
 
 		expirationCache *cache.Cache
+
+		hits   atomic.Uint64
+		misses atomic.Uint64
+
+		onEvict func(key uint64)
 	}
 
 	AdapterOptions func(a *Adapter) error
@@ -38,16 +49,11 @@ func NewAdapter(opts ...AdapterOptions) (*Adapter, error) {
 			return nil, err
 		}
 	}
+	a.logger = cacheadapter.Logger(a.logger, a.debug, "disk")
 
 	a.db = diskv.New(diskv.Options{
-		BasePath: a.directory,
-		Transform: func(s string) []string {
-			// "abcdef" -> ./cache/a/abcdef
-			return []string{
-				s[0:1],
-				s[1:],
-			}
-		},
+		BasePath:     a.directory,
+		Transform:    transform,
 		CacheSizeMax: a.maxMemorySize,
 	})
 
@@ -64,6 +70,18 @@ func WithDirectory(directory string) AdapterOptions {
 	}
 }
 
+// WithLogger sets the adapter logger, operations are logged at debug level.
+// Defaults to slog.Default().
+func WithLogger(logger *slog.Logger) AdapterOptions {
+	return func(a *Adapter) error {
+		a.logger = logger
+		return nil
+	}
+}
+
+// WithDebug logs the adapter operations to stderr.
+//
+// Deprecated: use WithLogger with a logger enabled at debug level.
 func WithDebug(debug bool) AdapterOptions {
 	return func(a *Adapter) error {
 		a.debug = debug
@@ -71,6 +89,15 @@ func WithDebug(debug bool) AdapterOptions {
 	}
 }
 
+// WithOnEvict sets a func called with the key of each entry removed on
+// expiration.
+func WithOnEvict(onEvict func(key uint64)) AdapterOptions {
+	return func(a *Adapter) error {
+		a.onEvict = onEvict
+		return nil
+	}
+}
+
 func WithMaxMemorySize(size uint64) AdapterOptions {
 	return func(a *Adapter) error {
 		a.maxMemorySize = size
@@ -81,23 +108,38 @@ func WithMaxMemorySize(size uint64) AdapterOptions {
 func (a *Adapter) Get(key uint64) ([]byte, bool) {
 	response, err := a.db.Read(a.key(key))
 	if err != nil {
-		if a.debug {
-			log.Infof("[disk][get] key: %s, from cache: %t", a.key(key), len(response) > 0)
-		}
+		a.misses.Add(1)
+		a.logger.Debug("cache get", "key", a.key(key), "hit", false)
 		return nil, false
 	}
 
-	if a.debug {
-		log.Infof("[disk][get] key: %s, from cache: %t", a.key(key), len(response) > 0)
+	if len(response) > 0 {
+		a.hits.Add(1)
+	} else {
+		a.misses.Add(1)
 	}
 
+	a.logger.Debug("cache get", "key", a.key(key), "hit", len(response) > 0)
+
 	return response, len(response) > 0
 }
 
-func (a *Adapter) Set(key uint64, response []byte, expiration time.Time) error {
-	if a.debug {
-		log.Infof("[disk][set] key: %s, duration: %s", a.key(key), time.Until(expiration))
+func (a *Adapter) GetWithExpiration(key uint64) ([]byte, time.Time, bool) {
+	response, ok := a.Get(key)
+	if !ok {
+		return nil, time.Time{}, false
+	}
+
+	_, expiration, ok := a.expirationCache.GetWithExpiration(a.key(key))
+	if !ok {
+		// left by a previous process, it expires with the next gc
+		expiration = time.Time{}
 	}
+	return response, expiration, true
+}
+
+func (a *Adapter) Set(key uint64, response []byte, expiration time.Time) error {
+	a.logger.Debug("cache set", "key", a.key(key), "duration", time.Until(expiration))
 
 	// diskv doesn't have TTL, so we need to emulate it
 	// on expirationCache eviction we will remove diskv entry
@@ -106,11 +148,54 @@ func (a *Adapter) Set(key uint64, response []byte, expiration time.Time) error {
 	return a.db.Write(a.key(key), response)
 }
 
-func (a *Adapter) Release(key uint64) error {
-	if a.debug {
-		log.Infof("[disk][delete] key: %s", a.key(key))
+// GetStream returns a reader of the response cached for a given key, read
+// from the disk as it is consumed. Closing the reader closes the file.
+func (a *Adapter) GetStream(key uint64) (io.ReadCloser, bool) {
+	// the file is opened directly, diskv would read it through its memory
+	// cache
+	f, err := os.Open(a.path(a.key(key)))
+	if err != nil {
+		a.misses.Add(1)
+		a.logger.Debug("cache get stream", "key", a.key(key), "hit", false)
+		return nil, false
+	}
+
+	a.hits.Add(1)
+	a.logger.Debug("cache get stream", "key", a.key(key), "hit", true)
+	return f, true
+}
+
+// SetStream caches the response read from r for a given key until an
+// expiration date. The response is written to a temporary file next to the
+// cache directory first, so that it is only visible once complete.
+func (a *Adapter) SetStream(key uint64, r io.Reader, expiration time.Time) error {
+	a.logger.Debug("cache set stream", "key", a.key(key), "duration", time.Until(expiration))
+
+	dir := filepath.Clean(a.directory) + ".tmp"
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, a.key(key)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
 	}
 
+	a.expirationCache.Set(a.key(key), struct{}{}, time.Until(expiration))
+	return a.db.Import(f.Name(), a.key(key), true)
+}
+
+func (a *Adapter) Release(key uint64) error {
+	a.logger.Debug("cache delete", "key", a.key(key))
+
 	err := a.db.Erase(a.key(key))
 	if os.IsNotExist(err) {
 		return nil
@@ -118,23 +203,96 @@ func (a *Adapter) Release(key uint64) error {
 	return err
 }
 
+func (a *Adapter) Keys() ([]uint64, error) {
+	var keys []uint64
+	for k := range a.db.Keys(nil) {
+		key, err := strconv.ParseUint(k, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (a *Adapter) Flush() error {
+	a.logger.Debug("cache flush")
+
+	a.expirationCache.Flush()
+	return a.db.EraseAll()
+}
+
+func (a *Adapter) Len() (int, error) {
+	n := 0
+	for range a.db.Keys(nil) {
+		n++
+	}
+	return n, nil
+}
+
+func (a *Adapter) Stats() (cacheadapter.Stats, error) {
+	stats := cacheadapter.Stats{
+		Hits:   a.hits.Load(),
+		Misses: a.misses.Load(),
+	}
+	err := filepath.WalkDir(a.directory, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		stats.Entries++
+		stats.Bytes += info.Size()
+		return nil
+	})
+	return stats, err
+}
+
 func (a *Adapter) key(key uint64) string {
 	return fmt.Sprintf("%d", key)
 }
 
+// path returns the path of the file diskv stores a key in.
+func (a *Adapter) path(k string) string {
+	return filepath.Join(append([]string{a.directory}, append(transform(k), k)...)...)
+}
+
+// transform returns the directories diskv stores a key in,
+// "abcdef" -> ./cache/a/bcdef/abcdef.
+func transform(s string) []string {
+	return []string{
+		s[0:1],
+		s[1:],
+	}
+}
+
 func (a *Adapter) evict(k string, _ any) {
-	if a.debug {
-		log.Infof("[disk][expired] key: %s", k)
+	if !a.db.Has(k) {
+		// released already
+		return
 	}
+	a.logger.Debug("cache expired", "key", k)
 
 	key, err := strconv.ParseUint(k, 0, 64)
 	if err != nil {
-		log.Error(err)
+		a.logger.Error("cache invalid key", "key", k, "error", err)
 		return
 	}
 
 	if err := a.Release(key); err != nil {
-		log.Error(err)
+		a.logger.Error("cache delete failed", "key", k, "error", err)
+		return
+	}
+	if a.onEvict != nil {
+		a.onEvict(key)
 	}
 }
 
@@ -145,11 +303,9 @@ func (a *Adapter) runCleaner() {
 		defer ticker.Stop()
 
 		for range ticker.C {
-			if a.debug {
-				log.Infof("[disk][gc] removing all cache")
-			}
-			if err := a.db.EraseAll(); err != nil {
-				log.Error(err)
+			a.logger.Debug("cache gc, removing all cache")
+			if err := a.Flush(); err != nil {
+				a.logger.Error("cache gc failed", "error", err)
 			}
 		}
 	}()
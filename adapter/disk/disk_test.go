@@ -1,8 +1,15 @@
 package disk
 
 import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	cache "github.com/coinpaprika/echo-http-cache"
@@ -142,6 +149,123 @@ func (suite *DiskTestSuite) Test() {
 	}
 }
 
+func (suite *DiskTestSuite) TestFlushLenStats() {
+	a := suite.adapter.(*Adapter)
+	suite.Require().NoError(a.Flush())
+
+	expiration := time.Now().Add(1 * time.Minute)
+	suite.Require().NoError(a.Set(1, []byte("value 1"), expiration))
+	suite.Require().NoError(a.Set(2, []byte("value 22"), expiration))
+	a.Get(1)
+	a.Get(3)
+
+	n, err := a.Len()
+	suite.Require().NoError(err)
+	suite.Equal(2, n)
+
+	stats, err := a.Stats()
+	suite.Require().NoError(err)
+	suite.Equal(2, stats.Entries)
+	suite.Equal(int64(15), stats.Bytes)
+	suite.Equal(uint64(1), stats.Hits)
+	suite.Equal(uint64(1), stats.Misses)
+
+	suite.Require().NoError(a.Flush())
+	n, err = a.Len()
+	suite.Require().NoError(err)
+	suite.Zero(n)
+	_, ok := a.Get(1)
+	suite.False(ok)
+}
+
+func (suite *DiskTestSuite) TestOnEvict() {
+	var evicted []uint64
+	a, err := NewAdapter(WithOnEvict(func(key uint64) {
+		evicted = append(evicted, key)
+	}))
+	suite.Require().NoError(err)
+
+	suite.Require().NoError(a.Set(1, []byte("value 1"), time.Now().Add(1*time.Millisecond)))
+	suite.Require().NoError(a.Set(2, []byte("value 2"), time.Now().Add(1*time.Millisecond)))
+	suite.Require().NoError(a.Release(2))
+	time.Sleep(5 * time.Millisecond)
+	a.expirationCache.DeleteExpired()
+
+	suite.Equal([]uint64{1}, evicted)
+	_, ok := a.Get(1)
+	suite.False(ok)
+}
+
+func (suite *DiskTestSuite) TestGetWithExpiration() {
+	a := suite.adapter.(*Adapter)
+	expiration := time.Now().Add(1 * time.Minute)
+	suite.Require().NoError(a.Set(1, []byte("value 1"), expiration))
+
+	b, got, ok := a.GetWithExpiration(1)
+	suite.Require().True(ok)
+	suite.Equal("value 1", string(b))
+	suite.WithinDuration(expiration, got, time.Millisecond)
+
+	suite.Require().NoError(a.Release(1))
+	_, _, ok = a.GetWithExpiration(1)
+	suite.False(ok)
+}
+
+func (suite *DiskTestSuite) TestStream() {
+	a, err := NewAdapter(WithDirectory(filepath.Join(suite.T().TempDir(), "cache")))
+	suite.Require().NoError(err)
+
+	suite.Require().NoError(a.SetStream(1, strings.NewReader("value 1"), time.Now().Add(1*time.Minute)))
+	r, ok := a.GetStream(1)
+	suite.Require().True(ok)
+	b, err := io.ReadAll(r)
+	suite.Require().NoError(err)
+	suite.Require().NoError(r.Close())
+	suite.Equal("value 1", string(b))
+
+	suite.Error(a.SetStream(2, iotest.ErrReader(errors.New("read failed")), time.Now().Add(1*time.Minute)))
+	_, ok = a.GetStream(2)
+	suite.False(ok)
+
+	n, err := a.Len()
+	suite.Require().NoError(err)
+	suite.Equal(1, n)
+}
+
+func (suite *DiskTestSuite) TestStreamNotHeldInMemory() {
+	a, err := NewAdapter(WithDirectory(filepath.Join(suite.T().TempDir(), "cache")))
+	suite.Require().NoError(err)
+
+	size := 8 * 1024 * 1024
+	suite.Require().NoError(a.SetStream(1, io.LimitReader(zeros{}, int64(size)), time.Now().Add(1*time.Minute)))
+
+	for i := 0; i < 2; i++ {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		r, ok := a.GetStream(1)
+		suite.Require().True(ok)
+		n, err := io.Copy(io.Discard, r)
+		suite.Require().NoError(err)
+		suite.Require().NoError(r.Close())
+		runtime.ReadMemStats(&after)
+
+		suite.Equal(int64(size), n)
+		suite.Less(after.TotalAlloc-before.TotalAlloc, uint64(size/8), "the body is not read into memory")
+
+		// closing the reader closes the file
+		_, err = r.Read(make([]byte, 1))
+		suite.ErrorIs(err, os.ErrClosed)
+	}
+}
+
+// zeros is an endless reader of zero bytes.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
 var adapter, _ = NewAdapter(WithDirectory("./tmp/cache"))
 
 func BenchmarkSet(b *testing.B) {
@@ -0,0 +1,148 @@
+package memory
+
+import (
+	"container/heap"
+	"fmt"
+)
+
+// Algorithm is the policy picking the entry evicted to make room for a new
+// one once the adapter capacity is reached.
+type Algorithm string
+
+const (
+	// LRU evicts the least recently used entry.
+	LRU Algorithm = "LRU"
+	// MRU evicts the most recently used entry.
+	MRU Algorithm = "MRU"
+	// LFU evicts the least frequently used entry.
+	LFU Algorithm = "LFU"
+	// MFU evicts the most frequently used entry.
+	MFU Algorithm = "MFU"
+)
+
+// WithAlgorithm sets the algorithm picking the entry evicted by a new one
+// once the capacity set with WithCapacity is reached.
+func WithAlgorithm(algorithm Algorithm) AdapterOptions {
+	return func(a *Adapter) error {
+		if _, ok := evictionOrders[algorithm]; !ok {
+			return fmt.Errorf("memory adapter algorithm %q is not supported", algorithm)
+		}
+		a.algorithm = algorithm
+		return nil
+	}
+}
+
+// entry tracks the size and accesses of a cached entry. Accesses are dated with a
+// counter rather than a clock, so that they are strictly ordered.
+type entry struct {
+	key        string
+	lastAccess uint64
+	frequency  int
+	size       int64
+	index      int
+}
+
+// evictionOrders returns, by algorithm, whether an entry is evicted before
+// another. Ties are broken by evicting the least recently used entry.
+var evictionOrders = map[Algorithm]func(a, b *entry) bool{
+	LRU: func(a, b *entry) bool {
+		return a.lastAccess < b.lastAccess
+	},
+	MRU: func(a, b *entry) bool {
+		return a.lastAccess > b.lastAccess
+	},
+	LFU: func(a, b *entry) bool {
+		if a.frequency != b.frequency {
+			return a.frequency < b.frequency
+		}
+		return a.lastAccess < b.lastAccess
+	},
+	MFU: func(a, b *entry) bool {
+		if a.frequency != b.frequency {
+			return a.frequency > b.frequency
+		}
+		return a.lastAccess < b.lastAccess
+	},
+}
+
+// evictionQueue is a heap of the cached entries, the next entry to evict
+// first.
+type evictionQueue struct {
+	entries []*entry
+	before  func(a, b *entry) bool
+}
+
+func (q *evictionQueue) Len() int {
+	return len(q.entries)
+}
+
+func (q *evictionQueue) Less(i, j int) bool {
+	return q.before(q.entries[i], q.entries[j])
+}
+
+func (q *evictionQueue) Swap(i, j int) {
+	q.entries[i], q.entries[j] = q.entries[j], q.entries[i]
+	q.entries[i].index = i
+	q.entries[j].index = j
+}
+
+func (q *evictionQueue) Push(x any) {
+	e := x.(*entry)
+	e.index = len(q.entries)
+	q.entries = append(q.entries, e)
+}
+
+func (q *evictionQueue) Pop() any {
+	n := len(q.entries) - 1
+	e := q.entries[n]
+	q.entries[n] = nil
+	q.entries = q.entries[:n]
+	return e
+}
+
+// touch records an access to an entry of the given size, a hit or a write,
+// and starts tracking it if needed. It must be called with the adapter lock
+// held.
+func (a *Adapter) touch(k string, size int64, hit bool) {
+	a.tick++
+	e, ok := a.entries[k]
+	if !ok {
+		e = &entry{key: k, lastAccess: a.tick, frequency: 1, size: size}
+		a.entries[k] = e
+		a.bytes += size
+		heap.Push(a.queue, e)
+		return
+	}
+
+	if hit {
+		e.frequency++
+	}
+	e.lastAccess = a.tick
+	a.bytes += size - e.size
+	e.size = size
+	heap.Fix(a.queue, e.index)
+}
+
+// untrack stops tracking an entry. It must be called with the adapter lock
+// held.
+func (a *Adapter) untrack(k string) {
+	if e, ok := a.entries[k]; ok {
+		heap.Remove(a.queue, e.index)
+		delete(a.entries, k)
+		a.bytes -= e.size
+	}
+}
+
+// evictOne evicts the next entry picked by the algorithm. It must be called
+// with the adapter lock held.
+func (a *Adapter) evictOne() {
+	e := heap.Pop(a.queue).(*entry)
+	delete(a.entries, e.key)
+	a.bytes -= e.size
+	a.logger.Debug("cache evicted", "key", e.key, "algorithm", a.algorithm, "frequency", e.frequency, "bytes", e.size)
+
+	// reported by the eviction callback, without tracking it again
+	a.evicting.Store(e.key, struct{}{})
+	defer a.evicting.Delete(e.key)
+	a.cache.Delete(e.key)
+}
@@ -0,0 +1,77 @@
+package memory
+
+import (
+	"context"
+	"time"
+)
+
+// minPrunedKeys is the size from which the expired keys of a key set are
+// pruned, whenever it doubled since they were last pruned.
+const minPrunedKeys = 64
+
+// keySet is a set of keys with their expiration dates.
+type keySet struct {
+	keys map[uint64]time.Time
+	// pruned is the size of the set once the expired keys were last pruned.
+	pruned int
+}
+
+// AddToIndex implements the cache Indexer interface AddToIndex method. Sets
+// are kept apart from the entries, they are not evicted to make room for
+// new entries.
+func (a *Adapter) AddToIndex(_ context.Context, name string, key uint64, expiration time.Time) error {
+	now := time.Now()
+	if !expiration.After(now) {
+		return nil
+	}
+
+	a.indexMu.Lock()
+	defer a.indexMu.Unlock()
+
+	set := &keySet{keys: map[uint64]time.Time{}}
+	setExpiration := expiration
+	if v, e, ok := a.indexes.GetWithExpiration(name); ok {
+		set = v.(*keySet)
+		if e.After(setExpiration) {
+			setExpiration = e
+		}
+	}
+	if e, ok := set.keys[key]; !ok || expiration.After(e) {
+		set.keys[key] = expiration
+	}
+	if len(set.keys) >= 2*max(set.pruned, minPrunedKeys) {
+		for k, e := range set.keys {
+			if !e.After(now) {
+				delete(set.keys, k)
+			}
+		}
+		set.pruned = len(set.keys)
+	}
+
+	a.logger.Debug("cache index add", "index", name, "key", a.key(key), "keys", len(set.keys))
+	a.indexes.Set(name, set, setExpiration.Sub(now))
+	return nil
+}
+
+// TakeIndex implements the cache Indexer interface TakeIndex method.
+func (a *Adapter) TakeIndex(_ context.Context, name string) ([]uint64, error) {
+	a.indexMu.Lock()
+	defer a.indexMu.Unlock()
+
+	v, ok := a.indexes.Get(name)
+	if !ok {
+		return nil, nil
+	}
+	a.indexes.Delete(name)
+
+	now := time.Now()
+	set := v.(*keySet)
+	keys := make([]uint64, 0, len(set.keys))
+	for k, e := range set.keys {
+		if e.After(now) {
+			keys = append(keys, k)
+		}
+	}
+	a.logger.Debug("cache index take", "index", name, "keys", len(keys))
+	return keys, nil
+}
@@ -2,33 +2,93 @@ package memory
 
 import (
 	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/labstack/gommon/log"
+	cacheadapter "github.com/coinpaprika/echo-http-cache/adapter"
 	"github.com/patrickmn/go-cache"
 )
 
 type (
 	Adapter struct {
-		cache    *cache.Cache
-		capacity int
-		debug    bool
+		cache         *cache.Cache
+		capacity      int
+		maxBytes      int64
+		maxEntryBytes int64
+		algorithm     Algorithm
+		debug         bool
+		logger        *slog.Logger
+
+		hits   atomic.Uint64
+		misses atomic.Uint64
+
+		onEvict  func(key uint64)
+		released sync.Map
+		evicting sync.Map
+
+		// key sets, kept apart from the entries
+		indexMu sync.Mutex
+		indexes *cache.Cache
+
+		// eviction tracking, once the capacity or max bytes is set
+		mu      sync.Mutex
+		entries map[string]*entry
+		queue   *evictionQueue
+		tick    uint64
+		bytes   int64
 	}
 	AdapterOptions func(a *Adapter) error
+
+	// item is a cached response with its accesses, writes included.
+	item struct {
+		response   []byte
+		frequency  atomic.Int64
+		lastAccess atomic.Int64
+	}
 )
 
+// newItem returns an item for a response written at a given date.
+func newItem(response []byte, now time.Time) *item {
+	it := &item{response: response}
+	it.frequency.Store(1)
+	it.lastAccess.Store(now.UnixNano())
+	return it
+}
+
+// hit records a hit on an item.
+func (it *item) hit(now time.Time) []byte {
+	it.frequency.Add(1)
+	it.lastAccess.Store(now.UnixNano())
+	return it.response
+}
+
 func NewAdapter(opts ...AdapterOptions) (*Adapter, error) {
 	a := &Adapter{
-		cache: cache.New(10*time.Minute, 30*time.Second),
+		cache:   cache.New(10*time.Minute, 30*time.Second),
+		indexes: cache.New(cache.NoExpiration, 30*time.Second),
 	}
 	for _, opt := range opts {
 		if err := opt(a); err != nil {
 			return nil, err
 		}
 	}
+	a.logger = cacheadapter.Logger(a.logger, a.debug, "memory")
+	if a.tracked() {
+		a.entries = map[string]*entry{}
+		a.queue = &evictionQueue{before: evictionOrders[a.algorithmOrDefault()]}
+	}
+	if a.onEvict != nil || a.tracked() {
+		a.cache.OnEvicted(a.evict)
+	}
 	return a, nil
 }
 
+// WithCapacity sets the maximum number of entries. Once it is reached, new
+// entries evict the entry picked by the algorithm set with WithAlgorithm, or
+// are not cached until expired entries are cleaned up without one.
 func WithCapacity(capacity int) AdapterOptions {
 	return func(a *Adapter) error {
 		a.capacity = capacity
@@ -36,6 +96,47 @@ func WithCapacity(capacity int) AdapterOptions {
 	}
 }
 
+// WithMaxBytes sets the maximum total size of the cached responses. Once it
+// is reached, new entries evict the entries picked by the algorithm set with
+// WithAlgorithm, or are not cached until expired entries are cleaned up
+// without one. Responses larger than maxBytes are never cached.
+func WithMaxBytes(maxBytes int64) AdapterOptions {
+	return func(a *Adapter) error {
+		a.maxBytes = maxBytes
+		return nil
+	}
+}
+
+// WithMaxEntryBytes sets the maximum size of a cached response, larger
+// responses are not cached.
+func WithMaxEntryBytes(maxEntryBytes int64) AdapterOptions {
+	return func(a *Adapter) error {
+		a.maxEntryBytes = maxEntryBytes
+		return nil
+	}
+}
+
+// WithOnEvict sets a func called with the key of each entry removed on
+// expiration.
+func WithOnEvict(onEvict func(key uint64)) AdapterOptions {
+	return func(a *Adapter) error {
+		a.onEvict = onEvict
+		return nil
+	}
+}
+
+// WithLogger sets the adapter logger, operations are logged at debug level.
+// Defaults to slog.Default().
+func WithLogger(logger *slog.Logger) AdapterOptions {
+	return func(a *Adapter) error {
+		a.logger = logger
+		return nil
+	}
+}
+
+// WithDebug logs the adapter operations to stderr.
+//
+// Deprecated: use WithLogger with a logger enabled at debug level.
 func WithDebug(debug bool) AdapterOptions {
 	return func(a *Adapter) error {
 		a.debug = debug
@@ -45,46 +146,213 @@ func WithDebug(debug bool) AdapterOptions {
 
 func (a *Adapter) Get(key uint64) ([]byte, bool) {
 	if v, ok := a.cache.Get(a.key(key)); ok {
-		if a.debug {
-			log.Infof("[memory][get] key: %s, from cache: true", a.key(key))
-		}
+		a.access(a.key(key))
+		a.hits.Add(1)
+		a.logger.Debug("cache get", "key", a.key(key), "hit", true)
 
-		return v.([]byte), true
+		return v.(*item).hit(time.Now()), true
 	}
 
-	if a.debug {
-		log.Infof("[memory][get] key: %s, from cache: false", a.key(key))
-	}
+	a.misses.Add(1)
+	a.logger.Debug("cache get", "key", a.key(key), "hit", false)
 	return nil, false
 }
 
+func (a *Adapter) GetWithExpiration(key uint64) ([]byte, time.Time, bool) {
+	if v, expiration, ok := a.cache.GetWithExpiration(a.key(key)); ok {
+		a.access(a.key(key))
+		a.hits.Add(1)
+		a.logger.Debug("cache get", "key", a.key(key), "hit", true)
+
+		return v.(*item).hit(time.Now()), expiration, true
+	}
+
+	a.misses.Add(1)
+	a.logger.Debug("cache get", "key", a.key(key), "hit", false)
+	return nil, time.Time{}, false
+}
+
 func (a *Adapter) Set(key uint64, response []byte, expiration time.Time) error {
-	if a.capacity > 0 && a.cache.ItemCount() >= a.capacity {
-		if a.debug {
-			log.Infof("[memory][set] key: %s omitted, over capacity, #items: %d", a.key(key), a.cache.ItemCount())
-		}
+	size := int64(len(response))
+	if a.maxEntryBytes > 0 && size > a.maxEntryBytes || a.maxBytes > 0 && size > a.maxBytes {
+		a.logger.Debug("cache set omitted, entry too large", "key", a.key(key), "bytes", size)
 
-		// it's better to not cache an item than DDoS the server
-		// we will wait for the cleanup goroutine to kick in within a few secs. and make a room for new entries
-		return nil
+		// the previous response must not be served instead
+		return a.Release(key)
 	}
 
-	if a.debug {
-		log.Infof("[memory][set] key: %s, duration: %s, #items: %d", a.key(key), time.Until(expiration), a.cache.ItemCount())
+	if a.tracked() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		for a.full(a.key(key), size) {
+			if a.algorithm == "" {
+				a.logger.Debug("cache set omitted, over capacity", "key", a.key(key), "items", len(a.entries), "bytes", a.bytes)
+
+				// it's better to not cache an item than DDoS the server
+				// we will wait for the cleanup goroutine to kick in within a few secs. and make a room for new entries
+				return nil
+			}
+			a.evictOne()
+		}
+		defer a.touch(a.key(key), size, false)
 	}
-	a.cache.Set(a.key(key), response, time.Until(expiration))
+
+	a.logger.Debug("cache set", "key", a.key(key), "duration", time.Until(expiration), "items", a.cache.ItemCount())
+	a.cache.Set(a.key(key), newItem(response, time.Now()), time.Until(expiration))
 	return nil
 }
 
 func (a *Adapter) Release(key uint64) error {
-	if a.debug {
-		log.Infof("[memory][delete] key: %s", a.key(key))
+	a.logger.Debug("cache delete", "key", a.key(key))
+
+	if a.tracked() {
+		a.mu.Lock()
+		a.untrack(a.key(key))
+		a.mu.Unlock()
 	}
 
+	// go-cache reports deleted entries as evicted
+	a.released.Store(a.key(key), struct{}{})
+	defer a.released.Delete(a.key(key))
 	a.cache.Delete(a.key(key))
 	return nil
 }
 
+// ReleaseLocal implements the cache LocalReleaser interface ReleaseLocal
+// method, entries being local to the instance.
+func (a *Adapter) ReleaseLocal(key uint64) error {
+	return a.Release(key)
+}
+
+// FlushLocal implements the cache LocalFlusher interface FlushLocal method,
+// entries being local to the instance.
+func (a *Adapter) FlushLocal() error {
+	return a.Flush()
+}
+
+// Accesses implements the cache AccessTracker interface Accesses method.
+func (a *Adapter) Accesses(key uint64) (int, time.Time, bool) {
+	v, ok := a.cache.Get(a.key(key))
+	if !ok {
+		return 0, time.Time{}, false
+	}
+	it := v.(*item)
+	return int(it.frequency.Load()), time.Unix(0, it.lastAccess.Load()), true
+}
+
+func (a *Adapter) Keys() ([]uint64, error) {
+	items := a.cache.Items()
+	keys := make([]uint64, 0, len(items))
+	for k := range items {
+		key, err := strconv.ParseUint(k, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (a *Adapter) Flush() error {
+	a.logger.Debug("cache flush", "items", a.cache.ItemCount())
+
+	a.cache.Flush()
+	a.indexMu.Lock()
+	a.indexes.Flush()
+	a.indexMu.Unlock()
+	if a.tracked() {
+		a.mu.Lock()
+		a.entries = map[string]*entry{}
+		a.queue.entries = nil
+		a.bytes = 0
+		a.mu.Unlock()
+	}
+	return nil
+}
+
+func (a *Adapter) Len() (int, error) {
+	return len(a.cache.Items()), nil
+}
+
+func (a *Adapter) Stats() (cacheadapter.Stats, error) {
+	items := a.cache.Items()
+	stats := cacheadapter.Stats{
+		Entries: len(items),
+		Hits:    a.hits.Load(),
+		Misses:  a.misses.Load(),
+	}
+	for _, v := range items {
+		stats.Bytes += int64(len(v.Object.(*item).response))
+	}
+	return stats, nil
+}
+
+func (a *Adapter) evict(k string, _ any) {
+	if _, ok := a.released.Load(k); ok {
+		return
+	}
+	if _, ok := a.evicting.Load(k); !ok {
+		a.logger.Debug("cache expired", "key", k)
+		if a.tracked() {
+			a.mu.Lock()
+			a.untrack(k)
+			a.mu.Unlock()
+		}
+	}
+	if a.onEvict == nil {
+		return
+	}
+
+	key, err := strconv.ParseUint(k, 10, 64)
+	if err != nil {
+		a.logger.Error("cache invalid key", "key", k, "error", err)
+		return
+	}
+	a.onEvict(key)
+}
+
+// tracked reports whether entries are tracked to bound the cache.
+func (a *Adapter) tracked() bool {
+	return a.capacity > 0 || a.maxBytes > 0
+}
+
+// full reports whether storing a response of the given size under k would
+// exceed the capacity or max bytes. It must be called with the adapter lock
+// held.
+func (a *Adapter) full(k string, size int64) bool {
+	e, ok := a.entries[k]
+	if a.capacity > 0 && !ok && len(a.entries) >= a.capacity {
+		return true
+	}
+	if ok {
+		size -= e.size
+	}
+	return a.maxBytes > 0 && a.bytes+size > a.maxBytes
+}
+
+// algorithmOrDefault returns the eviction algorithm, entries are ordered by
+// LRU when none is set.
+func (a *Adapter) algorithmOrDefault() Algorithm {
+	if a.algorithm == "" {
+		return LRU
+	}
+	return a.algorithm
+}
+
+// access records a hit on an entry, when accesses are tracked.
+func (a *Adapter) access(k string) {
+	if !a.tracked() {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if e, ok := a.entries[k]; ok {
+		a.touch(k, e.size, true)
+	}
+}
+
 func (a *Adapter) key(key uint64) string {
 	return fmt.Sprintf("%d", key)
 }
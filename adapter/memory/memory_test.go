@@ -1,7 +1,11 @@
 package memory
 
 import (
+	"bytes"
+	"context"
+	"log/slog"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -142,6 +146,314 @@ func (suite *MemoryTestSuite) Test() {
 	}
 }
 
+func (suite *MemoryTestSuite) TestFlushLenStats() {
+	a := suite.adapter.(*Adapter)
+	suite.Require().NoError(a.Flush())
+
+	expiration := time.Now().Add(1 * time.Minute)
+	suite.Require().NoError(a.Set(1, []byte("value 1"), expiration))
+	suite.Require().NoError(a.Set(2, []byte("value 22"), expiration))
+	a.Get(1)
+	a.Get(3)
+
+	n, err := a.Len()
+	suite.Require().NoError(err)
+	suite.Equal(2, n)
+
+	stats, err := a.Stats()
+	suite.Require().NoError(err)
+	suite.Equal(2, stats.Entries)
+	suite.Equal(int64(15), stats.Bytes)
+	suite.Equal(uint64(1), stats.Hits)
+	suite.Equal(uint64(1), stats.Misses)
+
+	suite.Require().NoError(a.Flush())
+	n, err = a.Len()
+	suite.Require().NoError(err)
+	suite.Zero(n)
+	_, ok := a.Get(1)
+	suite.False(ok)
+}
+
+func (suite *MemoryTestSuite) TestOnEvict() {
+	var evicted []uint64
+	a, err := NewAdapter(WithOnEvict(func(key uint64) {
+		evicted = append(evicted, key)
+	}))
+	suite.Require().NoError(err)
+
+	suite.Require().NoError(a.Set(1, []byte("value 1"), time.Now().Add(1*time.Millisecond)))
+	suite.Require().NoError(a.Set(2, []byte("value 2"), time.Now().Add(1*time.Minute)))
+	suite.Require().NoError(a.Release(2))
+	time.Sleep(5 * time.Millisecond)
+	a.cache.DeleteExpired()
+
+	suite.Equal([]uint64{1}, evicted)
+}
+
+func (suite *MemoryTestSuite) TestAlgorithm() {
+	tests := []struct {
+		algorithm   Algorithm
+		wantEvicted uint64
+	}{
+		{LRU, 2},
+		{MRU, 1},
+		{LFU, 2},
+		{MFU, 1},
+	}
+	for _, tt := range tests {
+		suite.Run(string(tt.algorithm), func() {
+			var evicted []uint64
+			a, err := NewAdapter(
+				WithCapacity(2),
+				WithAlgorithm(tt.algorithm),
+				WithOnEvict(func(key uint64) {
+					evicted = append(evicted, key)
+				}),
+			)
+			suite.Require().NoError(err)
+
+			expiration := time.Now().Add(1 * time.Minute)
+			suite.Require().NoError(a.Set(1, []byte("value 1"), expiration))
+			suite.Require().NoError(a.Set(2, []byte("value 2"), expiration))
+			// 1 is the most recently and the most frequently used
+			a.Get(2)
+			a.Get(1)
+			a.Get(1)
+			// updating an entry evicts nothing
+			suite.Require().NoError(a.Set(1, []byte("value 1"), expiration))
+			suite.Require().NoError(a.Set(3, []byte("value 3"), expiration))
+
+			suite.Equal([]uint64{tt.wantEvicted}, evicted)
+			n, err := a.Len()
+			suite.Require().NoError(err)
+			suite.Equal(2, n)
+			_, ok := a.Get(tt.wantEvicted)
+			suite.False(ok)
+			_, ok = a.Get(3)
+			suite.True(ok)
+		})
+	}
+}
+
+func (suite *MemoryTestSuite) TestCapacity() {
+	a, err := NewAdapter(WithCapacity(2), WithAlgorithm(LRU))
+	suite.Require().NoError(err)
+
+	expiration := time.Now().Add(1 * time.Minute)
+	suite.Require().NoError(a.Set(1, []byte("value 1"), expiration))
+	suite.Require().NoError(a.Set(2, []byte("value 2"), expiration))
+	suite.Require().NoError(a.Release(1))
+	suite.Require().NoError(a.Set(3, []byte("value 3"), time.Now().Add(1*time.Millisecond)))
+	time.Sleep(5 * time.Millisecond)
+	a.cache.DeleteExpired()
+	suite.Require().NoError(a.Set(4, []byte("value 4"), expiration))
+
+	// released and expired entries make room
+	_, ok := a.Get(2)
+	suite.True(ok)
+	_, ok = a.Get(4)
+	suite.True(ok)
+
+	suite.Require().NoError(a.Flush())
+	for key := uint64(5); key < 10; key++ {
+		suite.Require().NoError(a.Set(key, []byte("value"), expiration))
+	}
+	n, err := a.Len()
+	suite.Require().NoError(err)
+	suite.Equal(2, n)
+
+	_, err = NewAdapter(WithAlgorithm("FIFO"))
+	suite.Error(err)
+
+	// without algorithm, new entries are not cached
+	a, err = NewAdapter(WithCapacity(1))
+	suite.Require().NoError(err)
+	suite.Require().NoError(a.Set(1, []byte("value 1"), expiration))
+	suite.Require().NoError(a.Set(2, []byte("value 2"), expiration))
+	_, ok = a.Get(1)
+	suite.True(ok)
+	_, ok = a.Get(2)
+	suite.False(ok)
+}
+
+func (suite *MemoryTestSuite) TestMaxBytes() {
+	a, err := NewAdapter(WithMaxBytes(100), WithMaxEntryBytes(60), WithAlgorithm(LRU))
+	suite.Require().NoError(err)
+
+	expiration := time.Now().Add(1 * time.Minute)
+	suite.Require().NoError(a.Set(1, make([]byte, 40), expiration))
+	suite.Require().NoError(a.Set(2, make([]byte, 40), expiration))
+	_, ok := a.Get(1)
+	suite.True(ok)
+
+	// the least recently used entry makes room
+	suite.Require().NoError(a.Set(3, make([]byte, 50), expiration))
+	_, ok = a.Get(2)
+	suite.False(ok)
+	_, ok = a.Get(1)
+	suite.True(ok)
+	suite.Equal(int64(90), a.bytes)
+
+	// replacing an entry accounts for its new size
+	suite.Require().NoError(a.Set(1, make([]byte, 10), expiration))
+	suite.Equal(int64(60), a.bytes)
+
+	// entries over the max entry bytes are not cached
+	suite.Require().NoError(a.Set(3, make([]byte, 61), expiration))
+	_, ok = a.Get(3)
+	suite.False(ok)
+	suite.Equal(int64(10), a.bytes)
+
+	suite.Require().NoError(a.Release(1))
+	suite.Equal(int64(0), a.bytes)
+
+	// without algorithm, new entries are not cached
+	a, err = NewAdapter(WithMaxBytes(100))
+	suite.Require().NoError(err)
+	suite.Require().NoError(a.Set(1, make([]byte, 60), expiration))
+	suite.Require().NoError(a.Set(2, make([]byte, 60), expiration))
+	_, ok = a.Get(1)
+	suite.True(ok)
+	_, ok = a.Get(2)
+	suite.False(ok)
+
+	// expired entries make room
+	suite.Require().NoError(a.Set(1, make([]byte, 60), time.Now().Add(1*time.Millisecond)))
+	time.Sleep(5 * time.Millisecond)
+	a.cache.DeleteExpired()
+	suite.Require().NoError(a.Set(2, make([]byte, 60), expiration))
+	_, ok = a.Get(2)
+	suite.True(ok)
+}
+
+func (suite *MemoryTestSuite) TestCapacityConcurrency() {
+	a, err := NewAdapter(WithCapacity(10), WithMaxBytes(40), WithAlgorithm(LFU))
+	suite.Require().NoError(err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for key := uint64(0); key < 100; key++ {
+				_ = a.Set(key*uint64(i), []byte("value"), time.Now().Add(1*time.Minute))
+				a.Get(key)
+				_ = a.Release(key + 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	n, err := a.Len()
+	suite.Require().NoError(err)
+	suite.LessOrEqual(n, 10)
+	suite.Equal(n, len(a.entries))
+
+	stats, err := a.Stats()
+	suite.Require().NoError(err)
+	suite.LessOrEqual(stats.Bytes, int64(40))
+	suite.Equal(stats.Bytes, a.bytes)
+}
+
+func (suite *MemoryTestSuite) TestAccesses() {
+	var _ cache.AccessTracker = &Adapter{}
+
+	a := suite.adapter.(*Adapter)
+	start := time.Now()
+	suite.Require().NoError(a.Set(1, []byte("value 1"), time.Now().Add(1*time.Minute)))
+	frequency, stored, ok := a.Accesses(1)
+	suite.Require().True(ok)
+	suite.Equal(1, frequency)
+	suite.False(stored.Before(start))
+
+	a.Get(1)
+	a.GetWithExpiration(1)
+	frequency, lastAccess, ok := a.Accesses(1)
+	suite.Require().True(ok)
+	suite.Equal(3, frequency)
+	suite.False(lastAccess.Before(stored))
+
+	_, _, ok = a.Accesses(2)
+	suite.False(ok)
+}
+
+func (suite *MemoryTestSuite) TestReleaseLocal() {
+	var _ cache.LocalReleaser = &Adapter{}
+
+	suite.Require().NoError(suite.adapter.Set(1, []byte("value 1"), time.Now().Add(1*time.Minute)))
+	suite.Require().NoError(suite.adapter.(*Adapter).ReleaseLocal(1))
+	_, ok := suite.adapter.Get(1)
+	suite.False(ok)
+
+	var _ cache.LocalFlusher = &Adapter{}
+	suite.Require().NoError(suite.adapter.Set(2, []byte("value 2"), time.Now().Add(1*time.Minute)))
+	suite.Require().NoError(suite.adapter.(*Adapter).FlushLocal())
+	_, ok = suite.adapter.Get(2)
+	suite.False(ok)
+}
+
+func (suite *MemoryTestSuite) TestIndex() {
+	var _ cache.Indexer = &Adapter{}
+
+	a, err := NewAdapter(WithCapacity(1), WithAlgorithm(LRU))
+	suite.Require().NoError(err)
+	ctx := context.Background()
+
+	expiration := time.Now().Add(1 * time.Minute)
+	suite.Require().NoError(a.AddToIndex(ctx, "tag:a", 1, expiration))
+	suite.Require().NoError(a.AddToIndex(ctx, "tag:a", 2, time.Now().Add(1*time.Millisecond)))
+	suite.Require().NoError(a.AddToIndex(ctx, "tag:a", 3, time.Now().Add(-1*time.Minute)))
+	suite.Require().NoError(a.AddToIndex(ctx, "tag:b", 1, expiration))
+
+	// sets are not evicted to make room for entries
+	suite.Require().NoError(a.Set(1, []byte("value 1"), expiration))
+	suite.Require().NoError(a.Set(2, []byte("value 2"), expiration))
+
+	time.Sleep(5 * time.Millisecond)
+	keys, err := a.TakeIndex(ctx, "tag:a")
+	suite.Require().NoError(err)
+	suite.Equal([]uint64{1}, keys)
+	keys, err = a.TakeIndex(ctx, "tag:a")
+	suite.Require().NoError(err)
+	suite.Empty(keys)
+
+	suite.Require().NoError(a.Flush())
+	keys, err = a.TakeIndex(ctx, "tag:b")
+	suite.Require().NoError(err)
+	suite.Empty(keys)
+}
+
+func (suite *MemoryTestSuite) TestIndexConcurrency() {
+	a := suite.adapter.(*Adapter)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for key := uint64(0); key < 100; key++ {
+				_ = a.AddToIndex(ctx, "tag", uint64(i)*100+key, time.Now().Add(1*time.Minute))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	keys, err := a.TakeIndex(ctx, "tag")
+	suite.Require().NoError(err)
+	suite.Len(keys, 400)
+}
+
+func (suite *MemoryTestSuite) TestLogger() {
+	var buf bytes.Buffer
+	a, err := NewAdapter(WithLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	suite.Require().NoError(err)
+
+	a.Get(1)
+	suite.Contains(buf.String(), `level=DEBUG msg="cache get" adapter=memory key=1 hit=false`)
+}
+
 var adapter, _ = NewAdapter()
 
 func BenchmarkSet(b *testing.B) {
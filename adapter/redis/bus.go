@@ -0,0 +1,104 @@
+package redis
+
+import (
+	"context"
+	"encoding/binary"
+	"log/slog"
+
+	cacheadapter "github.com/coinpaprika/echo-http-cache/adapter"
+	"github.com/go-redis/redis/v8"
+)
+
+// DefaultChannel is the Redis channel releases are published to by default.
+const DefaultChannel = "echo-http-cache:release"
+
+type (
+	// Bus implements the cache Bus interface with Redis pub/sub. Keys are
+	// published as 8 byte big-endian integers, flushes as empty messages.
+	Bus struct {
+		ring    *redis.Ring
+		channel string
+		logger  *slog.Logger
+	}
+	BusOptions func(b *Bus)
+)
+
+// NewBus initializes a Redis pub/sub bus.
+func NewBus(opt *RingOptions, opts ...BusOptions) *Bus {
+	ropt := redis.RingOptions(*opt)
+	b := &Bus{
+		ring:    redis.NewRing(&ropt),
+		channel: DefaultChannel,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.logger = cacheadapter.Logger(b.logger, false, "redis")
+	return b
+}
+
+// WithChannel sets the Redis channel releases are published to, to
+// separate the caches sharing a Redis ring.
+func WithChannel(channel string) BusOptions {
+	return func(b *Bus) {
+		b.channel = channel
+	}
+}
+
+// WithBusLogger sets the bus logger. Defaults to slog.Default().
+func WithBusLogger(logger *slog.Logger) BusOptions {
+	return func(b *Bus) {
+		b.logger = logger
+	}
+}
+
+// Publish implements the cache Bus interface Publish method.
+func (b *Bus) Publish(ctx context.Context, keys []uint64) error {
+	msg := make([]byte, 0, 8*len(keys))
+	for _, key := range keys {
+		msg = binary.BigEndian.AppendUint64(msg, key)
+	}
+	b.logger.DebugContext(ctx, "cache release publish", "channel", b.channel, "keys", len(keys))
+
+	return b.ring.Publish(ctx, b.channel, msg).Err()
+}
+
+// Subscribe implements the cache Bus interface Subscribe method. It returns
+// once the subscription is confirmed by Redis, messages are then received
+// in the background.
+func (b *Bus) Subscribe(handler func(keys []uint64)) (func(), error) {
+	ctx := context.Background()
+	pubsub := b.ring.Subscribe(ctx, b.channel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	go func() {
+		for msg := range pubsub.Channel() {
+			payload := []byte(msg.Payload)
+			if len(payload)%8 != 0 {
+				b.logger.Error("cache release message invalid", "channel", msg.Channel, "size", len(payload))
+				continue
+			}
+
+			keys := make([]uint64, 0, len(payload)/8)
+			for ; len(payload) > 0; payload = payload[8:] {
+				keys = append(keys, binary.BigEndian.Uint64(payload))
+			}
+			b.logger.Debug("cache release received", "channel", msg.Channel, "keys", len(keys))
+			handler(keys)
+		}
+	}()
+
+	return func() {
+		if err := pubsub.Close(); err != nil {
+			b.logger.Error("cache release unsubscribe failed", "channel", b.channel, "error", err)
+		}
+	}, nil
+}
+
+// Close closes the bus connections.
+func (b *Bus) Close() error {
+	return b.ring.Close()
+}
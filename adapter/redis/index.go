@@ -0,0 +1,84 @@
+package redis
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	cache "github.com/coinpaprika/echo-http-cache"
+	"github.com/go-redis/redis/v8"
+)
+
+// addToIndexScript adds a key to a sorted set scored by expiration dates in
+// milliseconds, unless it is already there for longer, prunes the expired
+// keys and keeps the set until its last key expires.
+var addToIndexScript = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[2])
+if not score or tonumber(score) < tonumber(ARGV[1]) then
+	redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+end
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[3])
+local last = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+if last[2] then
+	redis.call('PEXPIREAT', KEYS[1], last[2])
+end
+return 0
+`)
+
+// takeIndexScript deletes a sorted set and returns its keys not expired yet.
+var takeIndexScript = redis.NewScript(`
+local keys = redis.call('ZRANGEBYSCORE', KEYS[1], '(' .. ARGV[1], '+inf')
+redis.call('DEL', KEYS[1])
+return keys
+`)
+
+// AddToIndex implements the cache Indexer interface AddToIndex method. Sets
+// are stored as sorted sets, updated atomically by a script.
+func (a *Adapter) AddToIndex(ctx context.Context, name string, key uint64, expiration time.Time) error {
+	a.logger.DebugContext(ctx, "cache index add", "index", name, "key", cache.KeyAsString(key))
+
+	ctx, cancel := a.context(ctx)
+	defer cancel()
+
+	return addToIndexScript.Run(ctx, a.ring, []string{a.indexKey(name)},
+		expiration.UnixMilli(), cache.KeyAsString(key), time.Now().UnixMilli()).Err()
+}
+
+// TakeIndex implements the cache Indexer interface TakeIndex method.
+func (a *Adapter) TakeIndex(ctx context.Context, name string) ([]uint64, error) {
+	a.logger.DebugContext(ctx, "cache index take", "index", name)
+
+	ctx, cancel := a.context(ctx)
+	defer cancel()
+
+	members, err := takeIndexScript.Run(ctx, a.ring, []string{a.indexKey(name)}, time.Now().UnixMilli()).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]uint64, 0, len(members))
+	for _, member := range members {
+		key, err := strconv.ParseUint(member, 36, 64)
+		if err != nil {
+			a.logger.ErrorContext(ctx, "cache index invalid key", "index", name, "key", member, "error", err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// indexKey returns the Redis key the named set is stored under.
+func (a *Adapter) indexKey(name string) string {
+	return a.prefix + "index:" + name
+}
+
+// AddToIndex implements the cache Indexer interface AddToIndex method.
+func (a *ContextAdapter) AddToIndex(ctx context.Context, name string, key uint64, expiration time.Time) error {
+	return a.adapter.AddToIndex(ctx, name, key, expiration)
+}
+
+// TakeIndex implements the cache Indexer interface TakeIndex method.
+func (a *ContextAdapter) TakeIndex(ctx context.Context, name string) ([]uint64, error) {
+	return a.adapter.TakeIndex(ctx, name)
+}
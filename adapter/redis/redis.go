@@ -26,18 +26,44 @@ package redis
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	cache "github.com/coinpaprika/echo-http-cache"
+	cacheadapter "github.com/coinpaprika/echo-http-cache/adapter"
 	redisCache "github.com/go-redis/cache/v8"
 	"github.com/go-redis/redis/v8"
-	"github.com/labstack/gommon/log"
 )
 
+// scanBatchSize is the number of keys scanned at once.
+const scanBatchSize = 1000
+
+// globEscaper escapes the glob pattern characters of a SCAN match.
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
 type (
 	Adapter struct {
-		store *redisCache.Cache
-		debug bool
+		ring    *redis.Ring
+		store   *redisCache.Cache
+		prefix  string
+		debug   bool
+		logger  *slog.Logger
+		timeout time.Duration
+
+		hits   atomic.Uint64
+		misses atomic.Uint64
+	}
+
+	// ContextAdapter implements the cache AdapterV2 interface, passing
+	// request contexts to Redis.
+	ContextAdapter struct {
+		adapter *Adapter
 	}
 	AdapterOptions func(a *Adapter)
 	RingOptions    redis.RingOptions
@@ -45,55 +71,272 @@ type (
 
 // Get implements the cache Adapter interface Get method.
 func (a *Adapter) Get(key uint64) ([]byte, bool) {
+	ctx, cancel := a.context(context.Background())
+	defer cancel()
+
+	c, err := a.get(ctx, key)
+	a.logger.Debug("cache get", "key", cache.KeyAsString(key), "hit", err == nil)
+	return c, err == nil
+}
+
+func (a *Adapter) get(ctx context.Context, key uint64) ([]byte, error) {
 	var c []byte
-	if err := a.store.Get(context.Background(), cache.KeyAsString(key), &c); err == nil {
-		if a.debug {
-			log.Infof("[redis][get] key: %s, from cache: true", cache.KeyAsString(key))
+	err := a.store.Get(ctx, a.key(key), &c)
+	if errors.Is(err, redisCache.ErrCacheMiss) {
+		a.misses.Add(1)
+		return nil, cache.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.hits.Add(1)
+	return c, nil
+}
+
+// getMulti reads entries with one round trip per shard. Responses are
+// stored as is by the store, they are not decoded.
+func (a *Adapter) getMulti(ctx context.Context, keys []uint64) (map[uint64][]byte, error) {
+	responses := make(map[uint64][]byte, len(keys))
+	if len(keys) == 0 {
+		return responses, nil
+	}
+
+	cmds := make([]*redis.StringCmd, len(keys))
+	_, err := a.ring.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Get(ctx, a.key(key))
 		}
-		return c, true
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	for i, cmd := range cmds {
+		c, err := cmd.Bytes()
+		if errors.Is(err, redis.Nil) {
+			a.misses.Add(1)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		a.hits.Add(1)
+		responses[keys[i]] = c
+	}
+	return responses, nil
+}
+
+// GetWithExpiration implements the cache Expirer interface
+// GetWithExpiration method.
+func (a *Adapter) GetWithExpiration(key uint64) ([]byte, time.Time, bool) {
+	ctx, cancel := a.context(context.Background())
+	defer cancel()
+
+	c, expiration, err := a.getWithExpiration(ctx, key)
+	a.logger.Debug("cache get", "key", cache.KeyAsString(key), "hit", err == nil)
+	return c, expiration, err == nil
+}
+
+func (a *Adapter) getWithExpiration(ctx context.Context, key uint64) ([]byte, time.Time, error) {
+	c, err := a.get(ctx, key)
+	if err != nil {
+		return nil, time.Time{}, err
 	}
 
-	if a.debug {
-		log.Infof("[redis][get] key: %s, from cache: false", cache.KeyAsString(key))
+	ttl, err := a.ring.PTTL(ctx, a.key(key)).Result()
+	if err != nil || ttl <= 0 {
+		return c, time.Time{}, nil
 	}
-	return nil, false
+	return c, time.Now().Add(ttl), nil
 }
 
 // Set implements the cache Adapter interface Set method.
 func (a *Adapter) Set(key uint64, response []byte, expiration time.Time) error {
-	if a.debug {
-		log.Infof("[redis][set] key: %s, duration: %s", cache.KeyAsString(key), time.Until(expiration))
+	a.logger.Debug("cache set", "key", cache.KeyAsString(key), "duration", time.Until(expiration))
+
+	ctx, cancel := a.context(context.Background())
+	defer cancel()
+
+	return a.set(ctx, key, response, expiration)
+}
+
+func (a *Adapter) set(ctx context.Context, key uint64, response []byte, expiration time.Time) error {
+	return a.store.Set(&redisCache.Item{
+		Ctx:   ctx,
+		Key:   a.key(key),
+		Value: response,
+		TTL:   ttl(expiration),
+	})
+}
+
+// setMulti writes entries with one round trip per shard, as the store
+// would write them one by one.
+func (a *Adapter) setMulti(ctx context.Context, entries []cache.Entry) error {
+	if len(entries) == 0 {
+		return nil
 	}
 
+	_, err := a.ring.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, entry := range entries {
+			b, err := a.store.Marshal(entry.Value)
+			if err != nil {
+				return err
+			}
+			pipe.Set(ctx, a.key(entry.Key), b, ttl(entry.Expiration))
+		}
+		return nil
+	})
+	return err
+}
+
+// ttl returns the Redis TTL of an entry expiring at a given date.
+func ttl(expiration time.Time) time.Duration {
 	ttl := time.Until(expiration)
 	if ttl.Seconds() <= 1 {
 		// REDIS TTL has to be > 1s
 		// otherwise warning is generated: `2022/11/28 11:37:00 too short TTL for key="2bsunt0a1fan6": 808.586939ms`
 		ttl = 1 * time.Second
 	}
-
-	return a.store.Set(&redisCache.Item{
-		Key:   cache.KeyAsString(key),
-		Value: response,
-		TTL:   ttl,
-	})
+	return ttl
 }
 
 // Release implements the cache Adapter interface Release method.
 func (a *Adapter) Release(key uint64) error {
-	if a.debug {
-		log.Infof("[redis][delete] key: %s", cache.KeyAsString(key))
+	a.logger.Debug("cache delete", "key", cache.KeyAsString(key))
+
+	return a.delete(context.Background(), key)
+}
+
+// Keys implements the cache Lister interface Keys method. The keys under
+// the adapter prefix are scanned on all shards, it requires a prefix.
+func (a *Adapter) Keys() ([]uint64, error) {
+	return a.keys(context.Background())
+}
+
+func (a *Adapter) keys(ctx context.Context) ([]uint64, error) {
+	var (
+		mu   sync.Mutex
+		keys []uint64
+	)
+	err := a.scan(ctx, func(_ context.Context, _ *redis.Client, redisKeys []string) error {
+		for _, k := range redisKeys {
+			// skipping the sets of keys
+			key, err := strconv.ParseUint(strings.TrimPrefix(k, a.prefix), 36, 64)
+			if err != nil {
+				continue
+			}
+			mu.Lock()
+			keys = append(keys, key)
+			mu.Unlock()
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// Flush implements the cache Flusher interface Flush method. The keys under
+// the adapter prefix are deleted, it requires a prefix.
+func (a *Adapter) Flush() error {
+	return a.flush(context.Background())
+}
+
+func (a *Adapter) flush(ctx context.Context) error {
+	a.logger.DebugContext(ctx, "cache flush")
+
+	return a.scan(ctx, func(ctx context.Context, client *redis.Client, keys []string) error {
+		return client.Del(ctx, keys...).Err()
+	})
+}
+
+// scan calls fn with the keys under the adapter prefix of each shard, in
+// batches. Without a prefix, the adapter keys cannot be told apart from the
+// keys of other applications sharing the ring.
+func (a *Adapter) scan(ctx context.Context, fn func(ctx context.Context, client *redis.Client, keys []string) error) error {
+	if a.prefix == "" {
+		return fmt.Errorf("redis adapter without prefix: %w", cache.ErrNotSupported)
+	}
+
+	match := globEscaper.Replace(a.prefix) + "*"
+	return a.ring.ForEachShard(ctx, func(ctx context.Context, client *redis.Client) error {
+		var cursor uint64
+		for {
+			keys, next, err := client.Scan(ctx, cursor, match, scanBatchSize).Result()
+			if err != nil {
+				return err
+			}
+			if len(keys) > 0 {
+				if err := fn(ctx, client, keys); err != nil {
+					return err
+				}
+			}
+			if cursor = next; cursor == 0 {
+				return nil
+			}
+		}
+	})
+}
+
+// Len implements the cache Counter interface Len method. It requires a
+// prefix.
+func (a *Adapter) Len() (int, error) {
+	keys, err := a.keys(context.Background())
+	return len(keys), err
+}
+
+// Stats implements the cache Stats interface Stats method. The size of
+// the entries is not reported. It requires a prefix.
+func (a *Adapter) Stats() (cacheadapter.Stats, error) {
+	keys, err := a.keys(context.Background())
+	if err != nil {
+		return cacheadapter.Stats{}, err
 	}
 
-	return a.store.Delete(context.Background(), cache.KeyAsString(key))
+	return cacheadapter.Stats{
+		Entries: len(keys),
+		Hits:    a.hits.Load(),
+		Misses:  a.misses.Load(),
+	}, nil
+}
+
+func (a *Adapter) delete(ctx context.Context, key uint64) error {
+	ctx, cancel := a.context(ctx)
+	defer cancel()
+
+	return a.store.Delete(ctx, a.key(key))
+}
+
+// key returns the Redis key an entry is stored under.
+func (a *Adapter) key(key uint64) string {
+	return a.prefix + cache.KeyAsString(key)
+}
+
+// context returns the context of a Redis operation, limited by the
+// adapter timeout.
+func (a *Adapter) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if a.timeout > 0 {
+		return context.WithTimeout(ctx, a.timeout)
+	}
+	return ctx, func() {}
 }
 
 // NewAdapter initializes Redis adapter.
 func NewAdapter(opt *RingOptions, opts ...AdapterOptions) cache.Adapter {
+	return newAdapter(opt, opts...)
+}
+
+// NewAdapterV2 initializes Redis adapter implementing the cache AdapterV2
+// interface.
+func NewAdapterV2(opt *RingOptions, opts ...AdapterOptions) cache.AdapterV2 {
+	return &ContextAdapter{adapter: newAdapter(opt, opts...)}
+}
+
+func newAdapter(opt *RingOptions, opts ...AdapterOptions) *Adapter {
 	ropt := redis.RingOptions(*opt)
+	ring := redis.NewRing(&ropt)
 	adapter := &Adapter{
+		ring: ring,
 		store: redisCache.New(&redisCache.Options{
-			Redis: redis.NewRing(&ropt),
+			Redis: ring,
 		}),
 		debug: false,
 	}
@@ -101,12 +344,137 @@ func NewAdapter(opt *RingOptions, opts ...AdapterOptions) cache.Adapter {
 	for _, opt := range opts {
 		opt(adapter)
 	}
+	adapter.logger = cacheadapter.Logger(adapter.logger, adapter.debug, "redis")
 
 	return adapter
 }
 
+// WithLogger sets the adapter logger, operations are logged at debug level.
+// Defaults to slog.Default().
+func WithLogger(logger *slog.Logger) AdapterOptions {
+	return func(a *Adapter) {
+		a.logger = logger
+	}
+}
+
+// WithDebug logs the adapter operations to stderr.
+//
+// Deprecated: use WithLogger with a logger enabled at debug level.
 func WithDebug(debug bool) AdapterOptions {
 	return func(a *Adapter) {
 		a.debug = debug
 	}
 }
+
+// WithPrefix sets the prefix of the Redis keys of the entries, to separate
+// them from the keys of other applications sharing the ring. Entries cannot
+// be listed, counted or flushed without a prefix, the default, which keeps
+// the keys written by previous releases. Setting a prefix leaves the entries
+// written without it behind until they expire.
+func WithPrefix(prefix string) AdapterOptions {
+	return func(a *Adapter) {
+		a.prefix = prefix
+	}
+}
+
+// WithTimeout sets the maximum duration of each Redis operation.
+func WithTimeout(timeout time.Duration) AdapterOptions {
+	return func(a *Adapter) {
+		a.timeout = timeout
+	}
+}
+
+// Get implements the cache AdapterV2 interface Get method.
+func (a *ContextAdapter) Get(ctx context.Context, key uint64) ([]byte, error) {
+	ctx, cancel := a.adapter.context(ctx)
+	defer cancel()
+
+	c, err := a.adapter.get(ctx, key)
+	a.adapter.logger.DebugContext(ctx, "cache get", "key", cache.KeyAsString(key), "hit", err == nil)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetMulti implements the cache AdapterV2 interface GetMulti method, with
+// one round trip per shard.
+func (a *ContextAdapter) GetMulti(ctx context.Context, keys []uint64) (map[uint64][]byte, error) {
+	a.adapter.logger.DebugContext(ctx, "cache get multi", "keys", len(keys))
+
+	ctx, cancel := a.adapter.context(ctx)
+	defer cancel()
+
+	return a.adapter.getMulti(ctx, keys)
+}
+
+// Set implements the cache AdapterV2 interface Set method.
+func (a *ContextAdapter) Set(ctx context.Context, key uint64, response []byte, expiration time.Time) error {
+	a.adapter.logger.DebugContext(ctx, "cache set", "key", cache.KeyAsString(key), "duration", time.Until(expiration))
+
+	ctx, cancel := a.adapter.context(ctx)
+	defer cancel()
+
+	return a.adapter.set(ctx, key, response, expiration)
+}
+
+// SetMulti implements the cache AdapterV2 interface SetMulti method, with
+// one round trip per shard.
+func (a *ContextAdapter) SetMulti(ctx context.Context, entries []cache.Entry) error {
+	a.adapter.logger.DebugContext(ctx, "cache set multi", "entries", len(entries))
+
+	ctx, cancel := a.adapter.context(ctx)
+	defer cancel()
+
+	return a.adapter.setMulti(ctx, entries)
+}
+
+// Delete implements the cache AdapterV2 interface Delete method.
+func (a *ContextAdapter) Delete(ctx context.Context, key uint64) error {
+	a.adapter.logger.DebugContext(ctx, "cache delete", "key", cache.KeyAsString(key))
+
+	return a.adapter.delete(ctx, key)
+}
+
+// Clear implements the cache AdapterV2 interface Clear method. The keys
+// under the adapter prefix are deleted, it requires a prefix.
+func (a *ContextAdapter) Clear(ctx context.Context) error {
+	return a.adapter.flush(ctx)
+}
+
+// Keys implements the cache Lister interface Keys method.
+func (a *ContextAdapter) Keys() ([]uint64, error) {
+	return a.adapter.Keys()
+}
+
+// Flush implements the cache Flusher interface Flush method.
+func (a *ContextAdapter) Flush() error {
+	return a.adapter.Flush()
+}
+
+// Len implements the cache Counter interface Len method.
+func (a *ContextAdapter) Len() (int, error) {
+	return a.adapter.Len()
+}
+
+// GetWithExpiration implements the cache Expirer interface
+// GetWithExpiration method.
+func (a *ContextAdapter) GetWithExpiration(key uint64) ([]byte, time.Time, bool) {
+	return a.adapter.GetWithExpiration(key)
+}
+
+// GetWithExpirationContext implements the cache ContextExpirer interface
+// GetWithExpirationContext method.
+func (a *ContextAdapter) GetWithExpirationContext(ctx context.Context, key uint64) ([]byte, time.Time, error) {
+	ctx, cancel := a.adapter.context(ctx)
+	defer cancel()
+
+	c, expiration, err := a.adapter.getWithExpiration(ctx, key)
+	a.adapter.logger.DebugContext(ctx, "cache get", "key", cache.KeyAsString(key), "hit", err == nil)
+	return c, expiration, err
+}
+
+// Stats implements the cache Stats interface Stats method.
+func (a *ContextAdapter) Stats() (cacheadapter.Stats, error) {
+	return a.adapter.Stats()
+}
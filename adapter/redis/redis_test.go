@@ -1,13 +1,16 @@
 package redis
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	cache "github.com/coinpaprika/echo-http-cache"
+	"github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
@@ -37,7 +40,7 @@ func (suite *RedisTestSuite) SetupTest() {
 		Addrs: map[string]string{
 			"server": fmt.Sprintf("%s:%s", host, port),
 		},
-	})
+	}, WithPrefix("echo-http-cache:"))
 }
 
 func (suite *RedisTestSuite) Test() {
@@ -155,6 +158,32 @@ func (suite *RedisTestSuite) Test() {
 	}
 }
 
+func (suite *RedisTestSuite) TestFlushLenStats() {
+	a := suite.adapter.(*Adapter)
+	suite.Require().NoError(a.Flush())
+
+	expiration := time.Now().Add(1 * time.Minute)
+	suite.Require().NoError(a.Set(1, []byte("value 1"), expiration))
+	suite.Require().NoError(a.Set(2, []byte("value 2"), expiration))
+	a.Get(1)
+	a.Get(3)
+
+	n, err := a.Len()
+	suite.Require().NoError(err)
+	suite.Equal(2, n)
+
+	stats, err := a.Stats()
+	suite.Require().NoError(err)
+	suite.Equal(2, stats.Entries)
+	suite.Equal(uint64(1), stats.Hits)
+	suite.Equal(uint64(1), stats.Misses)
+
+	suite.Require().NoError(a.Flush())
+	n, err = a.Len()
+	suite.Require().NoError(err)
+	suite.Zero(n)
+}
+
 var adapter = NewAdapter(&RingOptions{
 	Addrs: map[string]string{
 		"server": fmt.Sprintf("%s:%s", "localhost", "6379"),
@@ -170,3 +199,189 @@ func BenchmarkSet(b *testing.B) {
 		_ = adapter.Set(uint64(i), make([]byte, 100), time.Now().Add(1*time.Minute))
 	}
 }
+
+func (suite *RedisTestSuite) TestBus() {
+	host := os.Getenv("REDIS_HOST")
+	if host == "" {
+		host = "localhost"
+	}
+	port := os.Getenv("REDIS_PORT")
+	if port == "" {
+		port = "6379"
+	}
+	bus := NewBus(&RingOptions{
+		Addrs: map[string]string{
+			"server": fmt.Sprintf("%s:%s", host, port),
+		},
+	}, WithChannel("echo-http-cache:test"))
+	defer bus.Close()
+
+	received := make(chan []uint64, 1)
+	unsubscribe, err := bus.Subscribe(func(keys []uint64) {
+		received <- keys
+	})
+	suite.Require().NoError(err)
+	defer unsubscribe()
+
+	suite.Require().NoError(bus.Publish(context.Background(), []uint64{1, 1 << 63}))
+	select {
+	case keys := <-received:
+		suite.Equal([]uint64{1, 1 << 63}, keys)
+	case <-time.After(1 * time.Second):
+		suite.Fail("release not received")
+	}
+
+	// flushes are empty messages
+	suite.Require().NoError(bus.Publish(context.Background(), nil))
+	select {
+	case keys := <-received:
+		suite.Empty(keys)
+	case <-time.After(1 * time.Second):
+		suite.Fail("flush not received")
+	}
+}
+
+func (suite *RedisTestSuite) TestIndex() {
+	var _ cache.Indexer = &Adapter{}
+	var _ cache.Indexer = &ContextAdapter{}
+
+	a := suite.adapter.(*Adapter)
+	ctx := context.Background()
+	_, err := a.TakeIndex(ctx, "tag:test")
+	suite.Require().NoError(err)
+
+	expiration := time.Now().Add(1 * time.Minute)
+	suite.Require().NoError(a.AddToIndex(ctx, "tag:test", 1, expiration))
+	suite.Require().NoError(a.AddToIndex(ctx, "tag:test", 1<<63, expiration))
+	suite.Require().NoError(a.AddToIndex(ctx, "tag:test", 2, time.Now().Add(-1*time.Minute)))
+
+	ttl, err := a.ring.PTTL(ctx, a.indexKey("tag:test")).Result()
+	suite.Require().NoError(err)
+	suite.InDelta(time.Minute, ttl, float64(time.Second))
+
+	keys, err := a.TakeIndex(ctx, "tag:test")
+	suite.Require().NoError(err)
+	suite.ElementsMatch([]uint64{1, 1 << 63}, keys)
+
+	keys, err = a.TakeIndex(ctx, "tag:test")
+	suite.Require().NoError(err)
+	suite.Empty(keys)
+}
+
+func (suite *RedisTestSuite) TestPrefix() {
+	a := suite.adapter.(*Adapter)
+	ctx := context.Background()
+	other := newAdapter(&RingOptions{Addrs: a.ring.Options().Addrs}, WithPrefix("other:"))
+	unprefixed := newAdapter(&RingOptions{Addrs: a.ring.Options().Addrs})
+
+	expiration := time.Now().Add(1 * time.Minute)
+	suite.Require().NoError(a.ring.Set(ctx, "session", "value", time.Minute).Err())
+	suite.Require().NoError(a.Set(1, []byte("value 1"), expiration))
+	suite.Require().NoError(a.AddToIndex(ctx, "tag:prefix", 1, expiration))
+	suite.Require().NoError(other.Set(1, []byte("other value 1"), expiration))
+
+	keys, err := a.Keys()
+	suite.Require().NoError(err)
+	suite.Equal([]uint64{1}, keys)
+
+	// only the keys under the prefix are flushed
+	suite.Require().NoError(a.Flush())
+	suite.Equal(int64(0), a.ring.Exists(ctx, a.indexKey("tag:prefix")).Val())
+	suite.Equal("value", a.ring.Get(ctx, "session").Val())
+	_, ok := other.Get(1)
+	suite.True(ok)
+
+	// keys are not prefixed by default
+	suite.Require().NoError(unprefixed.Set(2, []byte("value 2"), expiration))
+	suite.Equal(int64(1), a.ring.Exists(ctx, cache.KeyAsString(2)).Val())
+	suite.ErrorIs(unprefixed.Flush(), cache.ErrNotSupported)
+	_, err = unprefixed.Keys()
+	suite.ErrorIs(err, cache.ErrNotSupported)
+	_, err = unprefixed.Len()
+	suite.ErrorIs(err, cache.ErrNotSupported)
+	suite.Require().NoError(unprefixed.Release(2))
+
+	suite.Require().NoError(other.Flush())
+	suite.Require().NoError(a.ring.Del(ctx, "session").Err())
+}
+
+// roundTrips counts the commands and pipelines sent to Redis.
+type roundTrips struct {
+	commands  int
+	pipelines int
+}
+
+func (h *roundTrips) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	h.commands++
+	return ctx, nil
+}
+
+func (h *roundTrips) AfterProcess(context.Context, redis.Cmder) error {
+	return nil
+}
+
+func (h *roundTrips) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	h.pipelines++
+	return ctx, nil
+}
+
+func (h *roundTrips) AfterProcessPipeline(context.Context, []redis.Cmder) error {
+	return nil
+}
+
+func (suite *RedisTestSuite) TestMulti() {
+	a := newAdapter(&RingOptions{Addrs: suite.adapter.(*Adapter).ring.Options().Addrs}, WithPrefix("echo-http-cache:"))
+	v2 := &ContextAdapter{adapter: a}
+	ctx := context.Background()
+	suite.Require().NoError(a.Flush())
+	hook := &roundTrips{}
+	a.ring.AddHook(hook)
+
+	expiration := time.Now().Add(1 * time.Minute)
+	suite.Require().NoError(v2.SetMulti(ctx, []cache.Entry{
+		{Key: 1, Value: []byte("value 1"), Expiration: expiration},
+		{Key: 2, Value: []byte(strings.Repeat("value 2", 100)), Expiration: expiration},
+	}))
+	responses, err := v2.GetMulti(ctx, []uint64{1, 2, 3})
+	suite.Require().NoError(err)
+	suite.Equal(map[uint64][]byte{1: []byte("value 1"), 2: []byte(strings.Repeat("value 2", 100))}, responses)
+
+	// one round trip per shard
+	suite.Zero(hook.commands)
+	suite.Equal(2, hook.pipelines)
+
+	// written as the store writes them
+	b, err := v2.Get(ctx, 2)
+	suite.Require().NoError(err)
+	suite.Equal(strings.Repeat("value 2", 100), string(b))
+	ttl, err := a.ring.PTTL(ctx, a.key(1)).Result()
+	suite.Require().NoError(err)
+	suite.InDelta(time.Minute, ttl, float64(time.Second))
+
+	stats, err := a.Stats()
+	suite.Require().NoError(err)
+	suite.Equal(uint64(3), stats.Hits)
+	suite.Equal(uint64(1), stats.Misses)
+	suite.Require().NoError(a.Flush())
+}
+
+func (suite *RedisTestSuite) TestGetWithExpirationContext() {
+	v2 := &ContextAdapter{adapter: suite.adapter.(*Adapter)}
+	ctx := context.Background()
+
+	expiration := time.Now().Add(1 * time.Minute)
+	suite.Require().NoError(v2.Set(ctx, 1, []byte("value 1"), expiration))
+	b, exp, err := v2.GetWithExpirationContext(ctx, 1)
+	suite.Require().NoError(err)
+	suite.Equal("value 1", string(b))
+	suite.WithinDuration(expiration, exp, time.Second)
+
+	_, _, err = v2.GetWithExpirationContext(ctx, 2)
+	suite.ErrorIs(err, cache.ErrNotFound)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, _, err = v2.GetWithExpirationContext(canceled, 1)
+	suite.ErrorIs(err, context.Canceled)
+	suite.Require().NoError(v2.Delete(ctx, 1))
+}
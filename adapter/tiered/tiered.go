@@ -0,0 +1,454 @@
+// Package tiered implements a cache adapter layering two adapters, such as
+// a memory adapter in front of a Redis adapter shared by many instances.
+package tiered
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	cache "github.com/coinpaprika/echo-http-cache"
+	cacheadapter "github.com/coinpaprika/echo-http-cache/adapter"
+)
+
+type (
+	// Adapter reads through its first tier (L1) then its second tier (L2),
+	// and writes to both.
+	Adapter struct {
+		l1       cache.AdapterV2
+		l2       cache.AdapterV2
+		maxL1TTL time.Duration
+		logger   *slog.Logger
+	}
+
+	// ContextAdapter implements the cache AdapterV2 interface, passing
+	// request contexts to both tiers.
+	ContextAdapter struct {
+		adapter *Adapter
+	}
+	AdapterOptions func(a *Adapter) error
+)
+
+// NewAdapter initializes a tiered adapter reading through l1 then l2.
+func NewAdapter(l1, l2 cache.Adapter, opts ...AdapterOptions) (*Adapter, error) {
+	if l1 == nil || l2 == nil {
+		return nil, errors.New("tiered adapter requires two adapters")
+	}
+	return newAdapter(cache.WrapAdapter(l1), cache.WrapAdapter(l2), opts...)
+}
+
+// NewAdapterV2 initializes a context aware tiered adapter reading through l1
+// then l2. Contexts are passed to both tiers, and L2 failures are returned
+// instead of being reported as misses. Adapters such as the memory adapter
+// are used as tiers through cache.WrapAdapter.
+func NewAdapterV2(l1, l2 cache.AdapterV2, opts ...AdapterOptions) (*ContextAdapter, error) {
+	if l1 == nil || l2 == nil {
+		return nil, errors.New("tiered adapter requires two adapters")
+	}
+	a, err := newAdapter(l1, l2, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &ContextAdapter{adapter: a}, nil
+}
+
+func newAdapter(l1, l2 cache.AdapterV2, opts ...AdapterOptions) (*Adapter, error) {
+	a := &Adapter{l1: l1, l2: l2}
+	for _, opt := range opts {
+		if err := opt(a); err != nil {
+			return nil, err
+		}
+	}
+	a.logger = cacheadapter.Logger(a.logger, false, "tiered")
+	return a, nil
+}
+
+// WithMaxL1TTL limits how long entries are kept in L1, so that changes made
+// to L2 by other instances are picked up. Zero means no limit.
+func WithMaxL1TTL(ttl time.Duration) AdapterOptions {
+	return func(a *Adapter) error {
+		if ttl < 0 {
+			return errors.New("tiered adapter max L1 ttl must not be negative")
+		}
+		a.maxL1TTL = ttl
+		return nil
+	}
+}
+
+// WithLogger sets the adapter logger, operations are logged at debug level.
+// Defaults to slog.Default().
+func WithLogger(logger *slog.Logger) AdapterOptions {
+	return func(a *Adapter) error {
+		a.logger = logger
+		return nil
+	}
+}
+
+// Get implements the cache Adapter interface Get method. Entries found in
+// L2 only are copied to L1 until they expire.
+func (a *Adapter) Get(key uint64) ([]byte, bool) {
+	response, _, ok := a.GetWithExpiration(key)
+	return response, ok
+}
+
+// GetWithExpiration implements the cache Expirer interface
+// GetWithExpiration method.
+func (a *Adapter) GetWithExpiration(key uint64) ([]byte, time.Time, bool) {
+	response, expiration, err := a.get(context.Background(), key)
+	if err != nil && !errors.Is(err, cache.ErrNotFound) {
+		a.logger.Error("cache get failed", "key", cache.KeyAsString(key), "error", err)
+	}
+	return response, expiration, err == nil
+}
+
+func (a *Adapter) get(ctx context.Context, key uint64) ([]byte, time.Time, error) {
+	response, expiration, err := getWithExpiration(ctx, a.l1, key)
+	if err == nil {
+		a.logger.DebugContext(ctx, "cache get", "key", cache.KeyAsString(key), "tier", 1)
+		return response, expiration, nil
+	}
+	if !errors.Is(err, cache.ErrNotFound) {
+		a.logger.ErrorContext(ctx, "cache get failed", "key", cache.KeyAsString(key), "tier", 1, "error", err)
+	}
+
+	response, expiration, err = getWithExpiration(ctx, a.l2, key)
+	if err != nil {
+		a.logger.DebugContext(ctx, "cache get", "key", cache.KeyAsString(key), "hit", false)
+		if errors.Is(err, cache.ErrNotFound) {
+			return nil, time.Time{}, err
+		}
+		return nil, time.Time{}, fmt.Errorf("L2: %w", err)
+	}
+	a.logger.DebugContext(ctx, "cache get", "key", cache.KeyAsString(key), "tier", 2)
+
+	if expiration.IsZero() {
+		expiration = responseExpiration(response)
+	}
+	if expiration.After(time.Now()) {
+		if err := a.l1.Set(ctx, key, response, a.l1Expiration(expiration)); err != nil {
+			a.logger.ErrorContext(ctx, "cache backfill failed", "key", cache.KeyAsString(key), "error", err)
+		}
+	}
+	return response, expiration, nil
+}
+
+// getMulti reads the keys missing from L1 with a single L2 read, and copies
+// the entries found to L1 until they expire.
+func (a *Adapter) getMulti(ctx context.Context, keys []uint64) (map[uint64][]byte, error) {
+	responses, err := a.l1.GetMulti(ctx, keys)
+	if err != nil {
+		a.logger.ErrorContext(ctx, "cache get multi failed", "tier", 1, "error", err)
+		responses = make(map[uint64][]byte, len(keys))
+	}
+
+	var missing []uint64
+	for _, key := range keys {
+		if _, ok := responses[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) == 0 {
+		return responses, nil
+	}
+
+	found, err := a.l2.GetMulti(ctx, missing)
+	if err != nil {
+		return nil, fmt.Errorf("L2: %w", err)
+	}
+	now := time.Now()
+	backfill := make([]cache.Entry, 0, len(found))
+	for key, response := range found {
+		responses[key] = response
+		if expiration := responseExpiration(response); expiration.After(now) {
+			backfill = append(backfill, cache.Entry{Key: key, Value: response, Expiration: a.l1Expiration(expiration)})
+		}
+	}
+	if len(backfill) > 0 {
+		if err := a.l1.SetMulti(ctx, backfill); err != nil {
+			a.logger.ErrorContext(ctx, "cache backfill failed", "error", err)
+		}
+	}
+	return responses, nil
+}
+
+// Set implements the cache Adapter interface Set method.
+func (a *Adapter) Set(key uint64, response []byte, expiration time.Time) error {
+	return a.set(context.Background(), key, response, expiration)
+}
+
+func (a *Adapter) set(ctx context.Context, key uint64, response []byte, expiration time.Time) error {
+	a.logger.DebugContext(ctx, "cache set", "key", cache.KeyAsString(key), "duration", time.Until(expiration))
+
+	var errs []error
+	if err := a.l2.Set(ctx, key, response, expiration); err != nil {
+		errs = append(errs, fmt.Errorf("L2: %w", err))
+	}
+	if err := a.l1.Set(ctx, key, response, a.l1Expiration(expiration)); err != nil {
+		errs = append(errs, fmt.Errorf("L1: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+func (a *Adapter) setMulti(ctx context.Context, entries []cache.Entry) error {
+	a.logger.DebugContext(ctx, "cache set multi", "entries", len(entries))
+
+	var errs []error
+	if err := a.l2.SetMulti(ctx, entries); err != nil {
+		errs = append(errs, fmt.Errorf("L2: %w", err))
+	}
+	l1Entries := make([]cache.Entry, len(entries))
+	for i, entry := range entries {
+		entry.Expiration = a.l1Expiration(entry.Expiration)
+		l1Entries[i] = entry
+	}
+	if err := a.l1.SetMulti(ctx, l1Entries); err != nil {
+		errs = append(errs, fmt.Errorf("L1: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// Release implements the cache Adapter interface Release method.
+func (a *Adapter) Release(key uint64) error {
+	return a.delete(context.Background(), key)
+}
+
+func (a *Adapter) delete(ctx context.Context, key uint64) error {
+	a.logger.DebugContext(ctx, "cache delete", "key", cache.KeyAsString(key))
+
+	var errs []error
+	if err := a.l1.Delete(ctx, key); err != nil {
+		errs = append(errs, fmt.Errorf("L1: %w", err))
+	}
+	if err := a.l2.Delete(ctx, key); err != nil {
+		errs = append(errs, fmt.Errorf("L2: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// ReleaseLocal implements the cache LocalReleaser interface ReleaseLocal
+// method, freeing L1 only.
+func (a *Adapter) ReleaseLocal(key uint64) error {
+	a.logger.Debug("cache delete", "key", cache.KeyAsString(key), "tier", 1)
+
+	return a.l1.Delete(context.Background(), key)
+}
+
+// FlushLocal implements the cache LocalFlusher interface FlushLocal method,
+// flushing L1 only. L1 must support it.
+func (a *Adapter) FlushLocal() error {
+	a.logger.Debug("cache flush", "tier", 1)
+
+	return a.l1.Clear(context.Background())
+}
+
+// Accesses implements the cache AccessTracker interface Accesses method,
+// reporting the accesses to L1 when it tracks them.
+func (a *Adapter) Accesses(key uint64) (int, time.Time, bool) {
+	tracker, ok := tierAs[cache.AccessTracker](a.l1)
+	if !ok {
+		return 0, time.Time{}, false
+	}
+	return tracker.Accesses(key)
+}
+
+// AddToIndex implements the cache Indexer interface AddToIndex method. Sets
+// are stored by L2, which must implement it.
+func (a *Adapter) AddToIndex(ctx context.Context, name string, key uint64, expiration time.Time) error {
+	indexer, ok := tierAs[cache.Indexer](a.l2)
+	if !ok {
+		return fmt.Errorf("L2: %w", cache.ErrNotSupported)
+	}
+	return indexer.AddToIndex(ctx, name, key, expiration)
+}
+
+// TakeIndex implements the cache Indexer interface TakeIndex method. Sets
+// are stored by L2, which must implement it.
+func (a *Adapter) TakeIndex(ctx context.Context, name string) ([]uint64, error) {
+	indexer, ok := tierAs[cache.Indexer](a.l2)
+	if !ok {
+		return nil, fmt.Errorf("L2: %w", cache.ErrNotSupported)
+	}
+	return indexer.TakeIndex(ctx, name)
+}
+
+// Flush implements the cache Flusher interface Flush method. Both tiers must
+// implement it.
+func (a *Adapter) Flush() error {
+	return a.clear(context.Background())
+}
+
+func (a *Adapter) clear(ctx context.Context) error {
+	a.logger.DebugContext(ctx, "cache flush")
+
+	var errs []error
+	for i, tier := range []cache.AdapterV2{a.l1, a.l2} {
+		if err := tier.Clear(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("L%d: %w", i+1, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Keys implements the cache Lister interface Keys method, listing the keys
+// of both tiers. Both tiers must implement it.
+func (a *Adapter) Keys() ([]uint64, error) {
+	seen := map[uint64]struct{}{}
+	var keys []uint64
+	for i, tier := range []cache.AdapterV2{a.l1, a.l2} {
+		lister, ok := tierAs[cache.Lister](tier)
+		if !ok {
+			return nil, fmt.Errorf("L%d: %w", i+1, cache.ErrNotSupported)
+		}
+		tierKeys, err := lister.Keys()
+		if err != nil {
+			return nil, fmt.Errorf("L%d: %w", i+1, err)
+		}
+		for _, key := range tierKeys {
+			if _, ok := seen[key]; !ok {
+				seen[key] = struct{}{}
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// l1Expiration returns the expiration of an L1 entry, no later than the
+// max L1 ttl.
+func (a *Adapter) l1Expiration(expiration time.Time) time.Time {
+	if a.maxL1TTL > 0 {
+		if limit := time.Now().Add(a.maxL1TTL); expiration.IsZero() || expiration.After(limit) {
+			return limit
+		}
+	}
+	return expiration
+}
+
+// Get implements the cache AdapterV2 interface Get method. Entries found in
+// L2 only are copied to L1 until they expire.
+func (a *ContextAdapter) Get(ctx context.Context, key uint64) ([]byte, error) {
+	response, _, err := a.adapter.get(ctx, key)
+	return response, err
+}
+
+// GetMulti implements the cache AdapterV2 interface GetMulti method, reading
+// the keys missing from L1 with a single L2 read.
+func (a *ContextAdapter) GetMulti(ctx context.Context, keys []uint64) (map[uint64][]byte, error) {
+	return a.adapter.getMulti(ctx, keys)
+}
+
+// Set implements the cache AdapterV2 interface Set method.
+func (a *ContextAdapter) Set(ctx context.Context, key uint64, response []byte, expiration time.Time) error {
+	return a.adapter.set(ctx, key, response, expiration)
+}
+
+// SetMulti implements the cache AdapterV2 interface SetMulti method.
+func (a *ContextAdapter) SetMulti(ctx context.Context, entries []cache.Entry) error {
+	return a.adapter.setMulti(ctx, entries)
+}
+
+// Delete implements the cache AdapterV2 interface Delete method.
+func (a *ContextAdapter) Delete(ctx context.Context, key uint64) error {
+	return a.adapter.delete(ctx, key)
+}
+
+// Clear implements the cache AdapterV2 interface Clear method. Both tiers
+// must support it.
+func (a *ContextAdapter) Clear(ctx context.Context) error {
+	return a.adapter.clear(ctx)
+}
+
+// GetWithExpirationContext implements the cache ContextExpirer interface
+// GetWithExpirationContext method.
+func (a *ContextAdapter) GetWithExpirationContext(ctx context.Context, key uint64) ([]byte, time.Time, error) {
+	return a.adapter.get(ctx, key)
+}
+
+// GetWithExpiration implements the cache Expirer interface
+// GetWithExpiration method.
+func (a *ContextAdapter) GetWithExpiration(key uint64) ([]byte, time.Time, bool) {
+	return a.adapter.GetWithExpiration(key)
+}
+
+// ReleaseLocal implements the cache LocalReleaser interface ReleaseLocal
+// method, freeing L1 only.
+func (a *ContextAdapter) ReleaseLocal(key uint64) error {
+	return a.adapter.ReleaseLocal(key)
+}
+
+// FlushLocal implements the cache LocalFlusher interface FlushLocal method,
+// flushing L1 only.
+func (a *ContextAdapter) FlushLocal() error {
+	return a.adapter.FlushLocal()
+}
+
+// Accesses implements the cache AccessTracker interface Accesses method.
+func (a *ContextAdapter) Accesses(key uint64) (int, time.Time, bool) {
+	return a.adapter.Accesses(key)
+}
+
+// AddToIndex implements the cache Indexer interface AddToIndex method.
+func (a *ContextAdapter) AddToIndex(ctx context.Context, name string, key uint64, expiration time.Time) error {
+	return a.adapter.AddToIndex(ctx, name, key, expiration)
+}
+
+// TakeIndex implements the cache Indexer interface TakeIndex method.
+func (a *ContextAdapter) TakeIndex(ctx context.Context, name string) ([]uint64, error) {
+	return a.adapter.TakeIndex(ctx, name)
+}
+
+// Flush implements the cache Flusher interface Flush method.
+func (a *ContextAdapter) Flush() error {
+	return a.adapter.Flush()
+}
+
+// Keys implements the cache Lister interface Keys method.
+func (a *ContextAdapter) Keys() ([]uint64, error) {
+	return a.adapter.Keys()
+}
+
+// getWithExpiration reads an entry with its expiration date, when the tier
+// reports it.
+func getWithExpiration(ctx context.Context, tier cache.AdapterV2, key uint64) ([]byte, time.Time, error) {
+	if expirer, ok := tierAs[cache.ContextExpirer](tier); ok {
+		return expirer.GetWithExpirationContext(ctx, key)
+	}
+	if expirer, ok := tierAs[cache.Expirer](tier); ok {
+		if err := ctx.Err(); err != nil {
+			return nil, time.Time{}, err
+		}
+		response, expiration, ok := expirer.GetWithExpiration(key)
+		if !ok {
+			return nil, time.Time{}, cache.ErrNotFound
+		}
+		return response, expiration, nil
+	}
+	response, err := tier.Get(ctx, key)
+	return response, time.Time{}, err
+}
+
+// responseExpiration returns the expiration of an encoded response, ignoring
+// how long it may be served stale.
+func responseExpiration(response []byte) time.Time {
+	r, err := cache.DecodeResponse(response)
+	if err != nil {
+		return time.Time{}
+	}
+	return r.Expiration
+}
+
+// tierAs returns a tier, or the Adapter it wraps, as an optional interface
+// implementation.
+func tierAs[T any](tier cache.AdapterV2) (T, bool) {
+	if t, ok := tier.(T); ok {
+		return t, true
+	}
+	if w, ok := tier.(interface{ Unwrap() cache.Adapter }); ok {
+		t, ok := w.Unwrap().(T)
+		return t, ok
+	}
+	var zero T
+	return zero, false
+}
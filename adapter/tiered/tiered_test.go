@@ -0,0 +1,326 @@
+package tiered
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	cache "github.com/coinpaprika/echo-http-cache"
+	"github.com/coinpaprika/echo-http-cache/adapter/memory"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/suite"
+)
+
+// mapAdapter is an adapter not reporting expirations.
+type mapAdapter struct {
+	sync.Mutex
+	store map[uint64][]byte
+}
+
+func (a *mapAdapter) Get(key uint64) ([]byte, bool) {
+	a.Lock()
+	defer a.Unlock()
+	b, ok := a.store[key]
+	return b, ok
+}
+
+func (a *mapAdapter) Set(key uint64, response []byte, _ time.Time) error {
+	a.Lock()
+	defer a.Unlock()
+	a.store[key] = response
+	return nil
+}
+
+func (a *mapAdapter) Release(key uint64) error {
+	a.Lock()
+	defer a.Unlock()
+	delete(a.store, key)
+	return nil
+}
+
+type TieredTestSuite struct {
+	suite.Suite
+	l1      *memory.Adapter
+	l2      *memory.Adapter
+	adapter *Adapter
+}
+
+func TestTieredTestSuite(t *testing.T) {
+	suite.Run(t, new(TieredTestSuite))
+}
+
+func (suite *TieredTestSuite) SetupTest() {
+	var err error
+	suite.l1, err = memory.NewAdapter()
+	suite.Require().NoError(err)
+	suite.l2, err = memory.NewAdapter()
+	suite.Require().NoError(err)
+	suite.adapter, err = NewAdapter(suite.l1, suite.l2)
+	suite.Require().NoError(err)
+}
+
+func (suite *TieredTestSuite) TestSetGetRelease() {
+	expiration := time.Now().Add(1 * time.Minute)
+	suite.Require().NoError(suite.adapter.Set(1, []byte("value 1"), expiration))
+
+	for _, tier := range []cache.Adapter{suite.l1, suite.l2, suite.adapter} {
+		b, ok := tier.Get(1)
+		suite.True(ok)
+		suite.Equal("value 1", string(b))
+	}
+
+	suite.Require().NoError(suite.adapter.Release(1))
+	for _, tier := range []cache.Adapter{suite.l1, suite.l2, suite.adapter} {
+		_, ok := tier.Get(1)
+		suite.False(ok)
+	}
+}
+
+func (suite *TieredTestSuite) TestReleaseLocal() {
+	suite.Require().NoError(suite.adapter.Set(1, []byte("value 1"), time.Now().Add(1*time.Minute)))
+	suite.Require().NoError(suite.adapter.ReleaseLocal(1))
+
+	_, ok := suite.l1.Get(1)
+	suite.False(ok)
+	_, ok = suite.l2.Get(1)
+	suite.True(ok)
+
+	suite.Require().NoError(suite.adapter.Set(2, []byte("value 2"), time.Now().Add(1*time.Minute)))
+	suite.Require().NoError(suite.adapter.FlushLocal())
+	_, ok = suite.l1.Get(2)
+	suite.False(ok)
+	_, ok = suite.l2.Get(2)
+	suite.True(ok)
+}
+
+func (suite *TieredTestSuite) TestAccesses() {
+	suite.Require().NoError(suite.adapter.Set(1, []byte("value 1"), time.Now().Add(1*time.Minute)))
+	suite.adapter.Get(1)
+
+	// accesses are tracked by L1
+	frequency, _, ok := suite.adapter.Accesses(1)
+	suite.Require().True(ok)
+	suite.Equal(2, frequency)
+
+	adapter, err := NewAdapter(&mapAdapter{store: map[uint64][]byte{}}, suite.l2)
+	suite.Require().NoError(err)
+	_, _, ok = adapter.Accesses(1)
+	suite.False(ok)
+}
+
+func (suite *TieredTestSuite) TestBackfill() {
+	expiration := time.Now().Add(1 * time.Minute)
+	suite.Require().NoError(suite.l2.Set(1, []byte("value 1"), expiration))
+
+	b, ok := suite.adapter.Get(1)
+	suite.Require().True(ok)
+	suite.Equal("value 1", string(b))
+
+	b, l1Expiration, ok := suite.l1.GetWithExpiration(1)
+	suite.Require().True(ok)
+	suite.Equal("value 1", string(b))
+	suite.WithinDuration(expiration, l1Expiration, time.Millisecond)
+}
+
+func (suite *TieredTestSuite) TestBackfillResponseExpiration() {
+	l2 := &mapAdapter{store: map[uint64][]byte{}}
+	adapter, err := NewAdapter(suite.l1, l2)
+	suite.Require().NoError(err)
+
+	expiration := time.Now().Add(1 * time.Minute)
+	suite.Require().NoError(l2.Set(1, cache.Response{Value: []byte("value 1"), Expiration: expiration}.Bytes(), expiration))
+	suite.Require().NoError(l2.Set(2, cache.Response{Value: []byte("value 2"), Expiration: time.Now().Add(-1 * time.Minute)}.Bytes(), expiration))
+
+	_, ok := adapter.Get(1)
+	suite.Require().True(ok)
+	_, l1Expiration, ok := suite.l1.GetWithExpiration(1)
+	suite.Require().True(ok)
+	suite.WithinDuration(expiration, l1Expiration, time.Millisecond)
+
+	_, ok = adapter.Get(2)
+	suite.Require().True(ok)
+	_, ok = suite.l1.Get(2)
+	suite.False(ok, "expired responses are not copied to L1")
+}
+
+func (suite *TieredTestSuite) TestMaxL1TTL() {
+	adapter, err := NewAdapter(suite.l1, suite.l2, WithMaxL1TTL(1*time.Second))
+	suite.Require().NoError(err)
+
+	suite.Require().NoError(adapter.Set(1, []byte("value 1"), time.Now().Add(1*time.Minute)))
+	_, l1Expiration, ok := suite.l1.GetWithExpiration(1)
+	suite.Require().True(ok)
+	suite.WithinDuration(time.Now().Add(1*time.Second), l1Expiration, 100*time.Millisecond)
+	_, l2Expiration, ok := suite.l2.GetWithExpiration(1)
+	suite.Require().True(ok)
+	suite.WithinDuration(time.Now().Add(1*time.Minute), l2Expiration, 100*time.Millisecond)
+
+	_, err = NewAdapter(suite.l1, suite.l2, WithMaxL1TTL(-1))
+	suite.Error(err)
+}
+
+func (suite *TieredTestSuite) TestFlushKeys() {
+	suite.Require().NoError(suite.adapter.Set(1, []byte("value 1"), time.Now().Add(1*time.Minute)))
+	suite.Require().NoError(suite.l2.Set(2, []byte("value 2"), time.Now().Add(1*time.Minute)))
+
+	keys, err := suite.adapter.Keys()
+	suite.Require().NoError(err)
+	suite.ElementsMatch([]uint64{1, 2}, keys)
+
+	suite.Require().NoError(suite.adapter.Flush())
+	keys, err = suite.adapter.Keys()
+	suite.Require().NoError(err)
+	suite.Empty(keys)
+
+	adapter, err := NewAdapter(suite.l1, &mapAdapter{store: map[uint64][]byte{}})
+	suite.Require().NoError(err)
+	suite.ErrorIs(adapter.Flush(), cache.ErrNotSupported)
+	_, err = adapter.Keys()
+	suite.ErrorIs(err, cache.ErrNotSupported)
+}
+
+func (suite *TieredTestSuite) TestIndex() {
+	ctx := context.Background()
+	suite.Require().NoError(suite.adapter.AddToIndex(ctx, "tag", 1, time.Now().Add(1*time.Minute)))
+
+	// sets are stored by L2
+	keys, err := suite.l2.TakeIndex(ctx, "tag")
+	suite.Require().NoError(err)
+	suite.Equal([]uint64{1}, keys)
+
+	adapter, err := NewAdapter(suite.l1, &mapAdapter{store: map[uint64][]byte{}})
+	suite.Require().NoError(err)
+	suite.ErrorIs(adapter.AddToIndex(ctx, "tag", 1, time.Now().Add(1*time.Minute)), cache.ErrNotSupported)
+	_, err = adapter.TakeIndex(ctx, "tag")
+	suite.ErrorIs(err, cache.ErrNotSupported)
+}
+
+// countingAdapter counts the writes to an adapter.
+type countingAdapter struct {
+	*memory.Adapter
+	sets int
+}
+
+func (a *countingAdapter) Set(key uint64, response []byte, expiration time.Time) error {
+	a.sets++
+	return a.Adapter.Set(key, response, expiration)
+}
+
+func (suite *TieredTestSuite) TestHitsStayLocal() {
+	l2 := &countingAdapter{Adapter: suite.l2}
+	adapter, err := NewAdapter(suite.l1, l2)
+	suite.Require().NoError(err)
+	client, err := cache.NewClient(
+		cache.ClientWithAdapter(adapter),
+		cache.ClientWithTTL(1*time.Minute),
+	)
+	suite.Require().NoError(err)
+
+	e := echo.New()
+	e.GET("/coins/btc", func(c echo.Context) error {
+		return c.String(http.StatusOK, "btc")
+	}, client.Middleware())
+	for i := 0; i < 5; i++ {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/coins/btc", nil))
+		suite.Equal("btc", rec.Body.String())
+	}
+
+	// hits are not written back
+	suite.Equal(1, l2.sets)
+}
+
+type ctxKey struct{}
+
+// storeAdapter is a context aware L2 recording the contexts it is given, and
+// failing with err once set.
+type storeAdapter struct {
+	cache.AdapterV2
+	contexts []context.Context
+	err      error
+}
+
+func (a *storeAdapter) Get(ctx context.Context, key uint64) ([]byte, error) {
+	a.contexts = append(a.contexts, ctx)
+	if a.err != nil {
+		return nil, a.err
+	}
+	return a.AdapterV2.Get(ctx, key)
+}
+
+func (a *storeAdapter) Set(ctx context.Context, key uint64, response []byte, expiration time.Time) error {
+	a.contexts = append(a.contexts, ctx)
+	if a.err != nil {
+		return a.err
+	}
+	return a.AdapterV2.Set(ctx, key, response, expiration)
+}
+
+func (suite *TieredTestSuite) TestContextAdapter() {
+	l2 := &storeAdapter{AdapterV2: cache.WrapAdapter(suite.l2)}
+	adapter, err := NewAdapterV2(cache.WrapAdapter(suite.l1), l2)
+	suite.Require().NoError(err)
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "request")
+	expiration := time.Now().Add(1 * time.Minute)
+	response := cache.Response{Value: []byte("value 1"), Expiration: expiration}.Bytes()
+	suite.Require().NoError(adapter.Set(ctx, 1, response, expiration))
+	suite.Require().NoError(suite.l1.Release(1))
+	b, err := adapter.Get(ctx, 1)
+	suite.Require().NoError(err)
+	suite.Equal(response, b)
+	suite.Require().Len(l2.contexts, 2)
+	for _, c := range l2.contexts {
+		suite.Equal("request", c.Value(ctxKey{}))
+	}
+	_, l1Expiration, ok := suite.l1.GetWithExpiration(1)
+	suite.Require().True(ok, "L2 hits are copied to L1")
+	suite.WithinDuration(expiration, l1Expiration, time.Millisecond)
+
+	_, err = adapter.Get(ctx, 2)
+	suite.ErrorIs(err, cache.ErrNotFound)
+
+	// store failures are not misses
+	l2.err = errors.New("connection refused")
+	_, err = adapter.Get(ctx, 2)
+	suite.ErrorIs(err, l2.err)
+	suite.NotErrorIs(err, cache.ErrNotFound)
+	suite.ErrorIs(adapter.Set(ctx, 2, []byte("value 2"), expiration), l2.err)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	l2.err = nil
+	_, err = adapter.Get(canceled, 2)
+	suite.ErrorIs(err, context.Canceled)
+}
+
+func (suite *TieredTestSuite) TestContextAdapterMulti() {
+	adapter, err := NewAdapterV2(cache.WrapAdapter(suite.l1), cache.WrapAdapter(suite.l2), WithMaxL1TTL(1*time.Second))
+	suite.Require().NoError(err)
+	ctx := context.Background()
+
+	expiration := time.Now().Add(1 * time.Minute)
+	suite.Require().NoError(adapter.SetMulti(ctx, []cache.Entry{{Key: 1, Value: []byte("value 1"), Expiration: expiration}}))
+	suite.Require().NoError(suite.l2.Set(2, cache.Response{Value: []byte("value 2"), Expiration: expiration}.Bytes(), expiration))
+	_, l1Expiration, ok := suite.l1.GetWithExpiration(1)
+	suite.Require().True(ok)
+	suite.WithinDuration(time.Now().Add(1*time.Second), l1Expiration, 100*time.Millisecond)
+
+	responses, err := adapter.GetMulti(ctx, []uint64{1, 2, 3})
+	suite.Require().NoError(err)
+	suite.Len(responses, 2)
+	suite.Equal("value 1", string(responses[1]))
+	_, ok = suite.l1.Get(2)
+	suite.True(ok, "L2 hits are copied to L1")
+
+	suite.Require().NoError(adapter.Delete(ctx, 1))
+	_, err = adapter.Get(ctx, 1)
+	suite.ErrorIs(err, cache.ErrNotFound)
+	suite.Require().NoError(adapter.Clear(ctx))
+	_, err = adapter.Get(ctx, 2)
+	suite.ErrorIs(err, cache.ErrNotFound)
+}
@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned by AdapterV2 implementations when no response
+	// is cached for a key.
+	ErrNotFound = errors.New("cache: entry not found")
+
+	// ErrNotSupported is returned by AdapterV2 implementations for
+	// operations the underlying store cannot perform.
+	ErrNotSupported = errors.New("cache: operation not supported by adapter")
+)
+
+// Entry is a cached response written with AdapterV2.SetMulti.
+type Entry struct {
+	Key        uint64
+	Value      []byte
+	Expiration time.Time
+}
+
+// AdapterV2 is the context aware adapter interface for HTTP cache
+// middleware client. Unlike Adapter, it distinguishes a cache miss from a
+// failing store. Existing Adapter implementations can be used through
+// WrapAdapter.
+type AdapterV2 interface {
+	// Get retrieves the cached response by a given key. It returns
+	// ErrNotFound when the key is not cached.
+	Get(ctx context.Context, key uint64) ([]byte, error)
+
+	// GetMulti retrieves the cached responses by the given keys. Keys not
+	// cached are missing from the returned map.
+	GetMulti(ctx context.Context, keys []uint64) (map[uint64][]byte, error)
+
+	// Set caches a response for a given key until an expiration date.
+	Set(ctx context.Context, key uint64, response []byte, expiration time.Time) error
+
+	// SetMulti caches responses until their expiration dates.
+	SetMulti(ctx context.Context, entries []Entry) error
+
+	// Delete frees cache for a given key.
+	Delete(ctx context.Context, key uint64) error
+
+	// Clear frees all cached responses.
+	Clear(ctx context.Context) error
+}
+
+// ContextExpirer is the context aware Expirer, implemented by AdapterV2
+// implementations able to tell when their entries expire.
+type ContextExpirer interface {
+	// GetWithExpirationContext retrieves the cached response by a given key
+	// with its expiration date, zero when it is unknown. It returns
+	// ErrNotFound when the key is not cached.
+	GetWithExpirationContext(ctx context.Context, key uint64) ([]byte, time.Time, error)
+}
+
+// WrapAdapter converts an Adapter into an AdapterV2. The context is only
+// checked for cancellation before each operation, as the Adapter methods
+// do not take one. Clear requires the Adapter to implement Flusher or Lister.
+func WrapAdapter(a Adapter) AdapterV2 {
+	return &adapterShim{adapter: a}
+}
+
+type adapterShim struct {
+	adapter Adapter
+}
+
+// Unwrap returns the wrapped Adapter.
+func (s *adapterShim) Unwrap() Adapter {
+	return s.adapter
+}
+
+func (s *adapterShim) Get(ctx context.Context, key uint64) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	b, ok := s.adapter.Get(key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return b, nil
+}
+
+func (s *adapterShim) GetMulti(ctx context.Context, keys []uint64) (map[uint64][]byte, error) {
+	responses := make(map[uint64][]byte, len(keys))
+	for _, key := range keys {
+		b, err := s.Get(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		responses[key] = b
+	}
+	return responses, nil
+}
+
+func (s *adapterShim) Set(ctx context.Context, key uint64, response []byte, expiration time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.adapter.Set(key, response, expiration)
+}
+
+func (s *adapterShim) SetMulti(ctx context.Context, entries []Entry) error {
+	for _, entry := range entries {
+		if err := s.Set(ctx, entry.Key, entry.Value, entry.Expiration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *adapterShim) Delete(ctx context.Context, key uint64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.adapter.Release(key)
+}
+
+func (s *adapterShim) Clear(ctx context.Context) error {
+	if flusher, ok := s.adapter.(Flusher); ok {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return flusher.Flush()
+	}
+
+	lister, ok := s.adapter.(Lister)
+	if !ok {
+		return ErrNotSupported
+	}
+	keys, err := lister.Keys()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, key := range keys {
+		if err := s.Delete(ctx, key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// adapterAs returns the client adapter, or the Adapter it wraps, as an
+// optional interface implementation.
+func adapterAs[T any](a AdapterV2) (T, bool) {
+	if t, ok := a.(T); ok {
+		return t, true
+	}
+	switch w := a.(type) {
+	case interface{ Unwrap() AdapterV2 }:
+		return adapterAs[T](w.Unwrap())
+	case interface{ Unwrap() Adapter }:
+		t, ok := w.Unwrap().(T)
+		return t, ok
+	}
+	var zero T
+	return zero, false
+}
@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coinpaprika/echo-http-cache/adapter/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrapAdapter(t *testing.T) {
+	ctx := context.Background()
+	expiration := time.Now().Add(1 * time.Minute)
+	adapter := WrapAdapter(&adapterMock{
+		store: map[uint64][]byte{},
+	})
+
+	_, err := adapter.Get(ctx, 1)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, adapter.Set(ctx, 1, []byte("one"), expiration))
+	require.NoError(t, adapter.SetMulti(ctx, []Entry{
+		{Key: 2, Value: []byte("two"), Expiration: expiration},
+		{Key: 3, Value: []byte("three"), Expiration: expiration},
+	}))
+
+	b, err := adapter.Get(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("one"), b)
+
+	responses, err := adapter.GetMulti(ctx, []uint64{1, 2, 4})
+	require.NoError(t, err)
+	assert.Equal(t, map[uint64][]byte{1: []byte("one"), 2: []byte("two")}, responses)
+
+	require.NoError(t, adapter.Delete(ctx, 1))
+	_, err = adapter.Get(ctx, 1)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.ErrorIs(t, adapter.Clear(ctx), ErrNotSupported)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = adapter.Get(canceled, 2)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, adapter.Set(canceled, 2, []byte("two"), expiration), context.Canceled)
+	assert.ErrorIs(t, adapter.Delete(canceled, 2), context.Canceled)
+}
+
+func TestWrapAdapterClear(t *testing.T) {
+	ctx := context.Background()
+	memcached, err := memory.NewAdapter()
+	require.NoError(t, err)
+
+	adapter := WrapAdapter(memcached)
+	require.NoError(t, adapter.SetMulti(ctx, []Entry{
+		{Key: 1, Value: []byte("one"), Expiration: time.Now().Add(1 * time.Minute)},
+		{Key: 2, Value: []byte("two"), Expiration: time.Now().Add(1 * time.Minute)},
+	}))
+	require.NoError(t, adapter.Clear(ctx))
+
+	responses, err := adapter.GetMulti(ctx, []uint64{1, 2})
+	require.NoError(t, err)
+	assert.Empty(t, responses)
+}
@@ -0,0 +1,217 @@
+package cache
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AdminEntry describes a cached response in admin endpoint responses.
+// LastAccess and Frequency are only reported by adapters implementing
+// AccessTracker, the reads of the admin endpoints included.
+type AdminEntry struct {
+	Key        string      `json:"key"`
+	Size       int         `json:"size"`
+	StatusCode int         `json:"status_code"`
+	Expiration time.Time   `json:"expiration"`
+	Created    time.Time   `json:"created"`
+	LastAccess *time.Time  `json:"last_access,omitempty"`
+	Frequency  int         `json:"frequency,omitempty"`
+	Header     http.Header `json:"header,omitempty"`
+	Value      string      `json:"value,omitempty"`
+}
+
+// AdminStats describes the adapter statistics in admin endpoint responses.
+type AdminStats struct {
+	Entries int    `json:"entries"`
+	Bytes   int64  `json:"bytes"`
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+}
+
+// AdminRoutes registers cache inspection and purge endpoints on a group:
+//
+//	GET    /entries                       lists cached responses
+//	DELETE /entries                       frees all cached responses
+//	GET    /entry?url=&method=&origin=    returns a cached response
+//	DELETE /entry?url=&method=&origin=    frees a cached response
+//	DELETE /prefix?path=                  frees cached responses by path prefix
+//	DELETE /tags?tag=                     frees cached responses by tag
+//	GET    /stats                         returns adapter statistics
+//
+// Listing responses requires an adapter implementing Lister, statistics
+// require an adapter implementing Stats. Accesses are listed for adapters
+// implementing AccessTracker. Flushes are published on the client bus, if
+// any, so that all instances free their local entries.
+// Every request must be allowed by authorize, a nil authorize denies all
+// requests.
+func (client *Client) AdminRoutes(g *echo.Group, authorize func(c echo.Context) bool) {
+	auth := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if authorize == nil || !authorize(c) {
+				return echo.ErrForbidden
+			}
+			return next(c)
+		}
+	}
+
+	g.GET("/entries", client.adminList, auth)
+	g.DELETE("/entries", client.adminFlush, auth)
+	g.GET("/entry", client.adminGet, auth)
+	g.DELETE("/entry", client.adminInvalidate, auth)
+	g.DELETE("/prefix", client.adminInvalidatePrefix, auth)
+	g.DELETE("/tags", client.adminInvalidateTags, auth)
+	g.GET("/stats", client.adminStats, auth)
+}
+
+func (client *Client) adminList(c echo.Context) error {
+	lister, ok := adapterAs[Lister](client.adapter)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "cache adapter does not support listing entries")
+	}
+	keys, err := lister.Keys()
+	if err != nil {
+		return err
+	}
+	responses, err := client.adapter.GetMulti(c.Request().Context(), keys)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]AdminEntry, 0, len(responses))
+	for key, b := range responses {
+		if _, ok := parseKeyIndex(b); ok {
+			continue
+		}
+		response, err := DecodeResponse(b)
+		if err != nil || len(response.Vary) > 0 {
+			continue
+		}
+		entries = append(entries, client.adminEntry(key, len(b), response))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Expiration.Before(entries[j].Expiration)
+	})
+
+	return c.JSON(http.StatusOK, entries)
+}
+
+func (client *Client) adminFlush(c echo.Context) error {
+	err := client.adapter.Clear(c.Request().Context())
+	if errors.Is(err, ErrNotSupported) {
+		return echo.NewHTTPError(http.StatusNotImplemented, "cache adapter does not support clearing entries")
+	}
+	if err != nil {
+		return err
+	}
+	client.publishFlush(c.Request().Context())
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (client *Client) adminGet(c echo.Context) error {
+	key, r, err := client.urlKey(adminMethod(c), c.QueryParam("url"), c.QueryParam("origin"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	storedKey, response, ok := client.lookup(key, r)
+	if !ok || len(response.Vary) > 0 {
+		return echo.ErrNotFound
+	}
+
+	entry := client.adminEntry(storedKey, len(response.Bytes()), response)
+	entry.Header = response.Header
+	value, err := client.adminValue(response)
+	if err != nil {
+		return err
+	}
+	entry.Value = string(value)
+	return c.JSON(http.StatusOK, entry)
+}
+
+// adminValue returns the body of a cached response, decompressed or read
+// from the stream adapter.
+func (client *Client) adminValue(response Response) ([]byte, error) {
+	if response.StreamKey != 0 {
+		if !client.openBody(&response) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "cache response body is not available")
+		}
+		defer response.body.Close()
+		return io.ReadAll(response.body)
+	}
+	if response.Encoding != "" {
+		return client.decompress(response)
+	}
+	return response.Value, nil
+}
+
+func (client *Client) adminInvalidate(c echo.Context) error {
+	if err := client.Invalidate(c.Request().Context(), adminMethod(c), c.QueryParam("url"), c.QueryParam("origin")); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (client *Client) adminInvalidatePrefix(c echo.Context) error {
+	if err := client.InvalidatePrefix(c.Request().Context(), c.QueryParam("path")); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (client *Client) adminInvalidateTags(c echo.Context) error {
+	if err := client.InvalidateTags(c.Request().Context(), c.QueryParams()["tag"]...); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (client *Client) adminStats(c echo.Context) error {
+	stats, ok := adapterAs[Stats](client.adapter)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "cache adapter does not support statistics")
+	}
+	s, err := stats.Stats()
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, AdminStats{
+		Entries: s.Entries,
+		Bytes:   s.Bytes,
+		Hits:    s.Hits,
+		Misses:  s.Misses,
+	})
+}
+
+func adminMethod(c echo.Context) string {
+	if method := c.QueryParam("method"); method != "" {
+		return method
+	}
+	return http.MethodGet
+}
+
+func (client *Client) adminEntry(key uint64, size int, response Response) AdminEntry {
+	statusCode := response.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+
+	entry := AdminEntry{
+		Key:        KeyAsString(key),
+		Size:       size,
+		StatusCode: statusCode,
+		Expiration: response.Expiration,
+		Created:    response.Created,
+	}
+	if tracker, ok := adapterAs[AccessTracker](client.adapter); ok {
+		if frequency, lastAccess, ok := tracker.Accesses(key); ok {
+			entry.Frequency = frequency
+			entry.LastAccess = &lastAccess
+		}
+	}
+	return entry
+}
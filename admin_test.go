@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coinpaprika/echo-http-cache/adapter/disk"
+	"github.com/coinpaprika/echo-http-cache/adapter/memory"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminRoutes(t *testing.T) {
+	memoryAdapter, err := memory.NewAdapter()
+	require.NoError(t, err)
+
+	client, err := NewClient(
+		ClientWithAdapter(memoryAdapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithPathIndex(true),
+	)
+	require.NoError(t, err)
+
+	e := echo.New()
+	client.AdminRoutes(e.Group("/admin/cache"), func(c echo.Context) bool {
+		return c.Request().Header.Get(echo.HeaderAuthorization) == "secret"
+	})
+	api := e.Group("/api", client.Middleware())
+	api.GET("/coins/:id", func(c echo.Context) error {
+		Tag(c, "coin")
+		return c.String(http.StatusOK, c.Param("id"))
+	})
+	api.GET("/tickers/:id", func(c echo.Context) error {
+		return c.String(http.StatusOK, c.Param("id"))
+	})
+
+	serve := func(method, target string, authorized bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if authorized {
+			req.Header.Set(echo.HeaderAuthorization, "secret")
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	list := func() []AdminEntry {
+		rec := serve(http.MethodGet, "/admin/cache/entries", true)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var entries []AdminEntry
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &entries))
+		return entries
+	}
+	populate := func() {
+		for _, u := range []string{"/api/coins/btc", "/api/coins/eth", "/api/tickers/btc"} {
+			serve(http.MethodGet, "http://foo.bar"+u, false)
+		}
+	}
+	entryURL := "/admin/cache/entry?url=" + url.QueryEscape("http://foo.bar/api/coins/btc")
+
+	populate()
+
+	t.Run("denies unauthorized requests", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, serve(http.MethodGet, "/admin/cache/entries", false).Code)
+		assert.Equal(t, http.StatusForbidden, serve(http.MethodDelete, "/admin/cache/entries", false).Code)
+	})
+
+	t.Run("lists entries", func(t *testing.T) {
+		serve(http.MethodGet, "http://foo.bar/api/coins/btc", false)
+		serve(http.MethodGet, "http://foo.bar/api/coins/btc", false)
+
+		entries := list()
+		require.Len(t, entries, 3)
+		var frequencies []int
+		for _, entry := range entries {
+			assert.Equal(t, http.StatusOK, entry.StatusCode)
+			assert.Positive(t, entry.Size)
+			assert.NotNil(t, entry.LastAccess)
+			frequencies = append(frequencies, entry.Frequency)
+		}
+		// the hits are tracked by the memory adapter
+		sort.Ints(frequencies)
+		assert.Equal(t, frequencies[0]+2, frequencies[2])
+		assert.Equal(t, frequencies[0], frequencies[1])
+	})
+
+	t.Run("gets entry", func(t *testing.T) {
+		rec := serve(http.MethodGet, entryURL, true)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var entry AdminEntry
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &entry))
+		assert.Equal(t, "btc", entry.Value)
+
+		assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/admin/cache/entry?url=/unknown", true).Code)
+	})
+
+	t.Run("purges entry", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, serve(http.MethodDelete, entryURL, true).Code)
+		assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, entryURL, true).Code)
+		assert.Len(t, list(), 2)
+	})
+
+	t.Run("purges by tag", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, serve(http.MethodDelete, "/admin/cache/tags?tag=coin", true).Code)
+		assert.Len(t, list(), 1)
+	})
+
+	t.Run("purges by prefix", func(t *testing.T) {
+		populate()
+		assert.Equal(t, http.StatusNoContent, serve(http.MethodDelete, "/admin/cache/prefix?path=/api/coins", true).Code)
+		assert.Len(t, list(), 1)
+	})
+
+	stats := func() AdminStats {
+		rec := serve(http.MethodGet, "/admin/cache/stats", true)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var s AdminStats
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &s))
+		return s
+	}
+
+	t.Run("reports stats", func(t *testing.T) {
+		s := stats()
+		assert.Positive(t, s.Entries)
+		assert.Positive(t, s.Bytes)
+		assert.Positive(t, s.Misses)
+	})
+
+	t.Run("flushes entries", func(t *testing.T) {
+		populate()
+		assert.Equal(t, http.StatusNoContent, serve(http.MethodDelete, "/admin/cache/entries", true).Code)
+		assert.Len(t, list(), 0)
+		assert.Zero(t, stats().Entries)
+	})
+}
+
+func TestAdminGetBody(t *testing.T) {
+	memoryAdapter, err := memory.NewAdapter()
+	require.NoError(t, err)
+	diskAdapter, err := disk.NewAdapter(disk.WithDirectory(filepath.Join(t.TempDir(), "cache")))
+	require.NoError(t, err)
+
+	body := strings.Repeat("btc ", 100)
+	tests := []struct {
+		name string
+		opts []ClientOption
+	}{
+		{"decompresses body", []ClientOption{ClientWithAdapter(memoryAdapter), ClientWithCodec(GzipCodec{})}},
+		{"reads streamed body", []ClientOption{ClientWithAdapter(diskAdapter), ClientWithStreaming(true)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewClient(append(tt.opts, ClientWithTTL(1*time.Minute))...)
+			require.NoError(t, err)
+
+			e := echo.New()
+			client.AdminRoutes(e.Group("/admin/cache"), func(_ echo.Context) bool { return true })
+			e.GET("/coins/btc", func(c echo.Context) error {
+				return c.String(http.StatusOK, body)
+			}, client.Middleware())
+
+			e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://foo.bar/coins/btc", nil))
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/cache/entry?url="+url.QueryEscape("http://foo.bar/coins/btc"), nil))
+			require.Equal(t, http.StatusOK, rec.Code)
+
+			var entry AdminEntry
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &entry))
+			assert.Equal(t, body, entry.Value)
+		})
+	}
+}
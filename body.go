@@ -0,0 +1,228 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"hash"
+	"hash/fnv"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+var errBodyTooLarge = errors.New("cache: response body exceeds the maximum cacheable size")
+
+// StreamAdapter is implemented by adapters able to store response bodies as
+// streams, so that they are never held in memory entirely.
+type StreamAdapter interface {
+	// GetStream returns a reader of the body cached for a given key. It
+	// also returns true or false, whether it exists or not.
+	GetStream(key uint64) (io.ReadCloser, bool)
+
+	// SetStream caches the body read from r for a given key until an
+	// expiration date. Nothing is cached when reading r fails.
+	SetStream(key uint64, r io.Reader, expiration time.Time) error
+}
+
+// ClientWithMaxBodySize sets the size of the largest response body cached.
+// Larger responses are still sent to the client, but they are not captured
+// past the limit and not cached. Zero means no limit.
+func ClientWithMaxBodySize(size int64) ClientOption {
+	return func(c *Client) error {
+		c.maxBodySize = size
+		return nil
+	}
+}
+
+// ClientWithStreaming turns on storing response bodies with the adapter
+// StreamAdapter implementation as they are sent to the client, and serving
+// them from it, instead of holding them in memory.
+func ClientWithStreaming(streaming bool) ClientOption {
+	return func(c *Client) error {
+		c.streaming = streaming
+		return nil
+	}
+}
+
+// bodyBuffer captures a response body up to a size limit. Past the limit,
+// the captured body is dropped and writes are discarded.
+type bodyBuffer struct {
+	bytes.Buffer
+	limit     int64
+	discarded bool
+}
+
+func (b *bodyBuffer) Write(p []byte) (int, error) {
+	if b.discarded {
+		return len(p), nil
+	}
+	if b.limit > 0 && int64(b.Len()+len(p)) > b.limit {
+		b.discard()
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// discard drops the captured body and stops capturing.
+func (b *bodyBuffer) discard() {
+	b.discarded = true
+	b.Buffer = bytes.Buffer{}
+}
+
+// isEventStream reports whether a response is a stream of server-sent
+// events, which never ends and so cannot be cached.
+func isEventStream(header http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(header.Get(echo.HeaderContentType))
+	return mediaType == "text/event-stream"
+}
+
+// streamResponseWriter sends a response to the client while writing its
+// body to a pipe, once open returns one for the response status code.
+type streamResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	limit      int64
+	open       func(statusCode int) *io.PipeWriter
+
+	pipe     *io.PipeWriter
+	started  bool
+	hash     hash.Hash64
+	size     int
+	overflow bool
+}
+
+func (w *streamResponseWriter) WriteHeader(code int) {
+	w.statusCode = code
+	if w.pipe = w.open(code); w.pipe != nil {
+		w.started = true
+		w.hash = fnv.New64a()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *streamResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	if w.pipe != nil && n > 0 {
+		w.size += n
+		if w.limit > 0 && int64(w.size) > w.limit {
+			w.overflow = true
+			w.close(errBodyTooLarge)
+		} else if _, perr := w.pipe.Write(b[:n]); perr != nil {
+			// the adapter gave up, SetStream reports why
+			w.pipe = nil
+		} else {
+			w.hash.Write(b[:n])
+		}
+	}
+	return n, err
+}
+
+func (w *streamResponseWriter) Flush() {
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (w *streamResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+// close ends the body written to the pipe, with an error when the body is
+// incomplete.
+func (w *streamResponseWriter) close(err error) {
+	if w.pipe == nil {
+		return
+	}
+	if err != nil {
+		w.pipe.CloseWithError(err)
+	} else {
+		w.pipe.Close()
+	}
+	w.pipe = nil
+}
+
+// fetchStream runs the handler for a cache miss, writing the response body
+// to the stream adapter as it is sent to the client. The response is cached
+// once the body is completely stored.
+func (client *Client) fetchStream(c echo.Context, next echo.HandlerFunc, key uint64, p Policy, stream StreamAdapter) (*fetched, error) {
+	done := make(chan error, 1)
+	original := c.Response().Writer
+	writer := &streamResponseWriter{ResponseWriter: original, limit: client.maxBodySize}
+	writer.open = func(statusCode int) *io.PipeWriter {
+		now := time.Now()
+		ttl, ok := client.storeTTL(p, statusCode, writer.Header(), now)
+		if !ok || isEventStream(writer.Header()) {
+			return nil
+		}
+
+		storedKey := key
+		if vary, _ := varyHeaders(writer.Header()); len(vary) > 0 {
+			storedKey = variantKey(key, vary, c.Request().Header)
+		}
+		pr, pw := io.Pipe()
+		go func() {
+			err := stream.SetStream(streamKey(storedKey), pr, now.Add(ttl+client.grace()))
+			// unblock the writer when the adapter gave up early
+			pr.Close()
+			done <- err
+		}()
+		return pw
+	}
+	c.Response().Writer = writer
+	defer writer.close(errors.New("cache: response aborted"))
+
+	err := next(c)
+	if err != nil && cacheableError(c, err) {
+		// streamed as the handler response
+		c.Error(err)
+		err = nil
+	}
+	c.Response().Writer = original
+	writer.close(err)
+	if err != nil {
+		c.Error(err)
+	}
+	if !writer.started {
+		return nil, nil
+	}
+
+	if serr := <-done; serr != nil {
+		if !writer.overflow && err == nil {
+			client.log().ErrorContext(c.Request().Context(), "cache stream set failed", "key", KeyAsString(key), "error", serr)
+		}
+		return nil, nil
+	}
+	if err != nil || writer.overflow {
+		return nil, nil
+	}
+
+	body := storedBody{hash: writer.hash.Sum64(), size: writer.size, streamed: true}
+	if storedKey, response, ok := client.storeBody(c, key, writer.statusCode, writer.Header(), body, p); ok {
+		return &fetched{key: storedKey, response: response}, nil
+	}
+	return nil, nil
+}
+
+// openBody opens the stream of a response whose body is stored with the
+// stream adapter. It returns false when the body is not available.
+func (client *Client) openBody(response *Response) bool {
+	if response.StreamKey == 0 {
+		return true
+	}
+	stream, ok := adapterAs[StreamAdapter](client.adapter)
+	if !ok {
+		return false
+	}
+	response.body, ok = stream.GetStream(response.StreamKey)
+	return ok
+}
+
+// streamKey returns the key the body of a response stored under key is
+// stored under with the stream adapter.
+func streamKey(key uint64) uint64 {
+	return generateKey("EHCS" + strconv.FormatUint(key, 10))
+}
@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+)
+
+// Bus broadcasts the keys of the entries released by an instance to all
+// the instances sharing a cache, so that they free them from the adapters
+// local to each instance, such as memory adapters or tiered adapters L1.
+// An empty list of keys broadcasts a flush of all entries.
+type Bus interface {
+	// Publish broadcasts the keys of released entries, or a flush when keys
+	// is empty.
+	Publish(ctx context.Context, keys []uint64) error
+
+	// Subscribe calls handler with the keys published by any instance,
+	// the subscribing one included, until unsubscribe is called.
+	Subscribe(handler func(keys []uint64)) (unsubscribe func(), err error)
+}
+
+// LocalReleaser is implemented by adapters keeping entries local to the
+// instance, such as memory adapters, or in front of shared ones. Keys
+// received from a Bus are only freed locally, clients with other adapters
+// do not subscribe to it.
+type LocalReleaser interface {
+	// ReleaseLocal frees the local cache for a given key.
+	ReleaseLocal(key uint64) error
+}
+
+// LocalFlusher is implemented by LocalReleaser adapters able to free all
+// their local entries, on flushes received from a Bus.
+type LocalFlusher interface {
+	// FlushLocal frees all the local cache.
+	FlushLocal() error
+}
+
+// ClientWithBus sets the bus releases are published to and received from.
+// The client subscribes to it until it is closed, when its adapter
+// implements LocalReleaser.
+func ClientWithBus(bus Bus) ClientOption {
+	return func(c *Client) error {
+		c.bus = bus
+		return nil
+	}
+}
+
+// Close unsubscribes the client from its bus, if any.
+func (client *Client) Close() error {
+	if client.unsubscribe != nil {
+		client.unsubscribe()
+		client.unsubscribe = nil
+	}
+	return nil
+}
+
+// subscribe subscribes the client to its bus, if any, when its adapter
+// keeps local entries.
+func (client *Client) subscribe() error {
+	if client.bus == nil {
+		return nil
+	}
+	local, ok := adapterAs[LocalReleaser](client.adapter)
+	if !ok {
+		return nil
+	}
+
+	unsubscribe, err := client.bus.Subscribe(func(keys []uint64) {
+		client.receive(local, keys)
+	})
+	if err != nil {
+		return fmt.Errorf("cache client bus subscription failed: %w", err)
+	}
+	client.unsubscribe = unsubscribe
+	return nil
+}
+
+// publish broadcasts the keys of released entries on the client bus, if
+// any. Failures are logged, the entries are released on this instance.
+func (client *Client) publish(ctx context.Context, keys ...uint64) {
+	if client.bus == nil || len(keys) == 0 {
+		return
+	}
+
+	if err := client.bus.Publish(ctx, keys); err != nil {
+		client.log().ErrorContext(ctx, "cache release publishing failed", "keys", len(keys), "error", err)
+	}
+}
+
+// publishFlush broadcasts a flush of all entries on the client bus, if any.
+// Failures are logged, the entries are flushed on this instance.
+func (client *Client) publishFlush(ctx context.Context) {
+	if client.bus == nil {
+		return
+	}
+
+	if err := client.bus.Publish(ctx, nil); err != nil {
+		client.log().ErrorContext(ctx, "cache flush publishing failed", "error", err)
+	}
+}
+
+// receive frees the local entries released by an instance, or all of them
+// on a flush.
+func (client *Client) receive(local LocalReleaser, keys []uint64) {
+	if len(keys) == 0 {
+		flusher, ok := local.(LocalFlusher)
+		if !ok {
+			client.log().Error("cache flush failed", "error", fmt.Errorf("local flush: %w", ErrNotSupported))
+			return
+		}
+		if err := flusher.FlushLocal(); err != nil {
+			client.log().Error("cache flush failed", "error", err)
+		}
+		return
+	}
+
+	for _, key := range keys {
+		if err := local.ReleaseLocal(key); err != nil {
+			client.log().Error("cache release failed", "key", KeyAsString(key), "error", err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// Package bus implements an in-process cache Bus, connecting the clients of
+// a single process, to test cross-instance invalidations.
+package bus
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// Local is a cache.Bus delivering the published keys to its subscribers
+// synchronously.
+type Local struct {
+	mu       sync.RWMutex
+	handlers map[int]func(keys []uint64)
+	next     int
+}
+
+// NewLocal initializes an in-process bus.
+func NewLocal() *Local {
+	return &Local{handlers: map[int]func(keys []uint64){}}
+}
+
+// Publish implements the cache Bus interface Publish method.
+func (b *Local) Publish(_ context.Context, keys []uint64) error {
+	b.mu.RLock()
+	handlers := make([]func(keys []uint64), 0, len(b.handlers))
+	for _, handler := range b.handlers {
+		handlers = append(handlers, handler)
+	}
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(slices.Clone(keys))
+	}
+	return nil
+}
+
+// Subscribe implements the cache Bus interface Subscribe method.
+func (b *Local) Subscribe(handler func(keys []uint64)) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.next
+	b.next++
+	b.handlers[id] = handler
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.handlers, id)
+	}, nil
+}
@@ -0,0 +1,169 @@
+package bus
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	cache "github.com/coinpaprika/echo-http-cache"
+	"github.com/coinpaprika/echo-http-cache/adapter/memory"
+	"github.com/coinpaprika/echo-http-cache/adapter/tiered"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocal(t *testing.T) {
+	bus := NewLocal()
+
+	var got [][]uint64
+	unsubscribe, err := bus.Subscribe(func(keys []uint64) {
+		got = append(got, keys)
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, bus.Publish(context.Background(), []uint64{1, 2}))
+	unsubscribe()
+	require.NoError(t, bus.Publish(context.Background(), []uint64{3}))
+
+	assert.Equal(t, [][]uint64{{1, 2}}, got)
+}
+
+func TestClientWithBus(t *testing.T) {
+	bus := NewLocal()
+	shared, err := memory.NewAdapter()
+	require.NoError(t, err)
+
+	// an instance with a memory L1 in front of the shared adapter
+	instance := func(body *string) *echo.Echo {
+		l1, err := memory.NewAdapter()
+		require.NoError(t, err)
+		adapter, err := tiered.NewAdapter(l1, shared)
+		require.NoError(t, err)
+
+		client, err := cache.NewClient(
+			cache.ClientWithAdapter(adapter),
+			cache.ClientWithTTL(1*time.Minute),
+			cache.ClientWithRefreshKey("refresh"),
+			cache.ClientWithBus(bus),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = client.Close() })
+
+		e := echo.New()
+		e.Use(client.Middleware())
+		e.GET("/coins/btc", func(c echo.Context) error {
+			return c.String(http.StatusOK, *body)
+		})
+		return e
+	}
+	serve := func(e *echo.Echo, url string) string {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec.Body.String()
+	}
+
+	bodyA, bodyB := "v1", "v1"
+	a, b := instance(&bodyA), instance(&bodyB)
+	assert.Equal(t, "v1", serve(a, "/coins/btc"))
+	assert.Equal(t, "v1", serve(b, "/coins/btc"))
+
+	bodyA, bodyB = "v2", "v2"
+	assert.Equal(t, "v2", serve(a, "/coins/btc?refresh"))
+	assert.Equal(t, "v2", serve(b, "/coins/btc"), "the L1 entry is released on all instances")
+}
+
+// sharedAdapter is an adapter shared by all instances, counting releases.
+type sharedAdapter struct {
+	sync.Mutex
+	store    map[uint64][]byte
+	releases int
+}
+
+func (a *sharedAdapter) Get(key uint64) ([]byte, bool) {
+	a.Lock()
+	defer a.Unlock()
+	b, ok := a.store[key]
+	return b, ok
+}
+
+func (a *sharedAdapter) Set(key uint64, response []byte, _ time.Time) error {
+	a.Lock()
+	defer a.Unlock()
+	a.store[key] = response
+	return nil
+}
+
+func (a *sharedAdapter) Release(key uint64) error {
+	a.Lock()
+	defer a.Unlock()
+	a.releases++
+	delete(a.store, key)
+	return nil
+}
+
+func TestClientWithBusShared(t *testing.T) {
+	bus := NewLocal()
+	shared := &sharedAdapter{store: map[uint64][]byte{}}
+
+	var clients []*cache.Client
+	for i := 0; i < 3; i++ {
+		client, err := cache.NewClient(
+			cache.ClientWithAdapter(shared),
+			cache.ClientWithTTL(1*time.Minute),
+			cache.ClientWithBus(bus),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = client.Close() })
+		clients = append(clients, client)
+	}
+
+	require.NoError(t, clients[0].Invalidate(context.Background(), http.MethodGet, "/coins/btc", ""))
+	assert.Equal(t, 1, shared.releases, "shared entries are not released by every instance")
+}
+
+func TestClientWithBusFlush(t *testing.T) {
+	bus := NewLocal()
+	shared, err := memory.NewAdapter()
+	require.NoError(t, err)
+
+	// an instance with a memory L1 in front of the shared adapter
+	instance := func(body *string) *echo.Echo {
+		l1, err := memory.NewAdapter()
+		require.NoError(t, err)
+		adapter, err := tiered.NewAdapter(l1, shared)
+		require.NoError(t, err)
+
+		client, err := cache.NewClient(
+			cache.ClientWithAdapter(adapter),
+			cache.ClientWithTTL(1*time.Minute),
+			cache.ClientWithBus(bus),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = client.Close() })
+
+		e := echo.New()
+		client.AdminRoutes(e.Group("/cache"), func(echo.Context) bool { return true })
+		e.GET("/coins/btc", func(c echo.Context) error {
+			return c.String(http.StatusOK, *body)
+		}, client.Middleware())
+		return e
+	}
+	serve := func(e *echo.Echo, method, url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(method, url, nil))
+		return rec
+	}
+
+	bodyA, bodyB := "v1", "v1"
+	a, b := instance(&bodyA), instance(&bodyB)
+	assert.Equal(t, "v1", serve(a, http.MethodGet, "/coins/btc").Body.String())
+	assert.Equal(t, "v1", serve(b, http.MethodGet, "/coins/btc").Body.String())
+
+	bodyA, bodyB = "v2", "v2"
+	assert.Equal(t, http.StatusNoContent, serve(a, http.MethodDelete, "/cache/entries").Code)
+	assert.Equal(t, "v2", serve(b, http.MethodGet, "/coins/btc").Body.String(), "the L1 entries are flushed on all instances")
+}
@@ -27,22 +27,27 @@ package cache
 import (
 	"bufio"
 	"bytes"
-	"encoding/gob"
+	"context"
 	"errors"
 	"fmt"
 	"hash/fnv"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
+	"path"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/coinpaprika/echo-http-cache/adapter"
 	"github.com/labstack/echo/v4"
-	"github.com/labstack/gommon/log"
 	"golang.org/x/exp/slices"
+	"golang.org/x/sync/singleflight"
 )
 
 // Response is the cached response data structure.
@@ -56,35 +61,99 @@ type Response struct {
 	// Expiration is the cached response expiration date.
 	Expiration time.Time
 
-	// LastAccess is the last date a cached response was accessed.
-	// Used by LRU and MRU algorithms.
+	// LastAccess is the date a cached response was stored. Hits are not
+	// written back to the adapter, see AccessTracker.
 	LastAccess time.Time
 
-	// Frequency is the count of times a cached response is accessed.
-	// Used for LFU and MFU algorithms.
+	// Frequency is 1 for a stored response. Hits are not written back to
+	// the adapter, see AccessTracker.
 	Frequency int
 
 	// StatusCode is the HTTP status code of the cached response.
 	StatusCode int
+
+	// Created is the date the response was cached. Used to compute the
+	// Age header.
+	Created time.Time
+
+	// Vary lists the request headers a cached response varies on. It is
+	// only set on the index entry stored under the request key, the
+	// response itself is stored under a key derived from the values of
+	// these request headers.
+	Vary []string
+
+	// StreamKey is the key the response body is stored under with a
+	// StreamAdapter, zero when the body is stored in Value.
+	StreamKey uint64
+
+	// Encoding is the content coding of the Codec Value is compressed
+	// with, empty when Value is not compressed.
+	Encoding string
+
+	// Path is the URL path of the request the response is cached for, set
+	// when the path index is enabled.
+	Path string
+
+	// body is the opened stream of a body stored with a StreamAdapter.
+	body io.ReadCloser
 }
 
 // Client data structure for HTTP cache middleware.
 type Client struct {
-	adapter         Adapter
+	adapter         AdapterV2
 	ttl             time.Duration
 	refreshKey      string
 	methods         []string
 	restrictedPaths []string
+	cacheControl    bool
+	conditional     bool
+	keyHeaders      []string
+	keyFunc         KeyFunc
+
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+	revalidating         sync.Map
+
+	coalescing bool
+	inflight   singleflight.Group
+
+	statusCodes []int
+	statusFunc  func(statusCode int) bool
+	negativeTTL time.Duration
+
+	indexMu   [64]sync.Mutex
+	pathIndex bool
+
+	policies      map[string]Policy
+	routePolicies sync.Map
+	// policyKeyHeaders is set once a policy overrides the key headers.
+	policyKeyHeaders atomic.Bool
+
+	metrics     Metrics
+	cacheStatus string
+	tracer      Tracer
+	logger      *slog.Logger
+
+	maxBodySize int64
+	streaming   bool
+	codec       Codec
+
+	bus         Bus
+	unsubscribe func()
 }
 
 type bodyDumpResponseWriter struct {
 	io.Writer
 	http.ResponseWriter
 	statusCode int
+	body       *bodyBuffer
 }
 
 func (w *bodyDumpResponseWriter) WriteHeader(code int) {
 	w.statusCode = code
+	if isEventStream(w.Header()) {
+		w.body.discard()
+	}
 	w.ResponseWriter.WriteHeader(code)
 }
 
@@ -100,6 +169,85 @@ func (w *bodyDumpResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return w.ResponseWriter.(http.Hijacker).Hijack()
 }
 
+// captureResponseWriter records a response without sending it to a client.
+// When the body exceeds the limit or is a stream of events, the response
+// is sent to the original writer instead, if any, or no longer recorded.
+type captureResponseWriter struct {
+	header     http.Header
+	body       bodyBuffer
+	statusCode int
+	original   http.ResponseWriter
+	streaming  bool
+}
+
+func (w *captureResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *captureResponseWriter) WriteHeader(code int) {
+	w.statusCode = code
+	if isEventStream(w.header) {
+		w.overflow()
+	}
+}
+
+func (w *captureResponseWriter) Write(b []byte) (int, error) {
+	if !w.streaming && w.body.limit > 0 && int64(w.body.Len()+len(b)) > w.body.limit {
+		w.overflow()
+	}
+	if w.streaming {
+		return w.original.Write(b)
+	}
+	return w.body.Write(b)
+}
+
+// Flush is a no-op, the response is sent once it is complete, unless it is
+// streamed to the original writer.
+func (w *captureResponseWriter) Flush() {
+	if w.streaming {
+		if f, ok := w.original.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+}
+
+// overflow stops recording the response, streaming it to the original
+// writer if any.
+func (w *captureResponseWriter) overflow() {
+	if w.streaming || w.body.discarded {
+		return
+	}
+	if w.original == nil {
+		w.body.discard()
+		return
+	}
+	if err := w.commit(); err == nil {
+		w.streaming = true
+	}
+	w.body.discard()
+}
+
+// commit sends the recorded response to the original writer.
+func (w *captureResponseWriter) commit() error {
+	header := w.original.Header()
+	for k := range header {
+		delete(header, k)
+	}
+	for k, v := range w.header {
+		header[k] = v
+	}
+	if w.statusCode == 0 {
+		return nil
+	}
+	w.original.WriteHeader(w.statusCode)
+	_, err := w.original.Write(w.body.Bytes())
+	return err
+}
+
+// KeyFunc generates the cache key of a request. Returning false opts the
+// request out of caching.
+type KeyFunc func(c echo.Context) (uint64, bool)
+
 // ClientOption is used to set Client settings.
 type ClientOption func(c *Client) error
 
@@ -116,105 +264,144 @@ type Adapter interface {
 	Release(key uint64) error
 }
 
+// Lister is implemented by adapters able to list the keys of all their
+// entries.
+type Lister interface {
+	// Keys returns the keys of all cached entries.
+	Keys() ([]uint64, error)
+}
+
+// Flusher is implemented by adapters able to free all their entries at
+// once.
+type Flusher interface {
+	// Flush frees all cached entries.
+	Flush() error
+}
+
+// Counter is implemented by adapters able to count their entries.
+type Counter interface {
+	// Len returns the number of cached entries.
+	Len() (int, error)
+}
+
+// Stats is implemented by adapters reporting usage statistics.
+type Stats interface {
+	// Stats returns the adapter usage statistics.
+	Stats() (adapter.Stats, error)
+}
+
+// Expirer is implemented by adapters able to tell when their entries
+// expire.
+type Expirer interface {
+	// GetWithExpiration retrieves the cached response by a given key with
+	// its expiration date, zero when it is unknown. It also returns true or
+	// false, whether it exists or not.
+	GetWithExpiration(key uint64) ([]byte, time.Time, bool)
+}
+
+// AccessTracker is implemented by adapters tracking the accesses to their
+// entries, such as the memory adapter.
+type AccessTracker interface {
+	// Accesses returns the number of accesses, the write included, and the
+	// date of the last access of the response cached for a given key. It
+	// also returns true or false, whether it exists or not.
+	Accesses(key uint64) (int, time.Time, bool)
+}
+
 // Middleware is the HTTP cache middleware handler.
 func (client *Client) Middleware() echo.MiddlewareFunc {
+	return client.middleware(nil)
+}
+
+// MiddlewareWithPolicy is the HTTP cache middleware handler using a policy
+// overriding the client and route policy settings. It is meant to be
+// attached to individual routes or groups instead of Middleware.
+func (client *Client) MiddlewareWithPolicy(p Policy) echo.MiddlewareFunc {
+	if p.KeyHeaders != nil {
+		client.policyKeyHeaders.Store(true)
+	}
+	return client.middleware(&p)
+}
+
+func (client *Client) middleware(override *Policy) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			if slices.Contains(client.restrictedPaths, c.Path()) {
+				client.bypass(c)
+				return next(c)
+			}
+			p := client.policy(c, override)
+			if p.Disabled {
+				client.bypass(c)
 				return next(c)
 			}
 			if client.cacheableMethod(c.Request().Method) {
 				sortURLParams(c.Request().URL)
-				key := generateKey(c.Request().URL.String() + c.Request().Header.Get(echo.HeaderOrigin))
-				if c.Request().Method == http.MethodPost && c.Request().Body != nil {
-					body, err := io.ReadAll(c.Request().Body)
-					defer c.Request().Body.Close()
-					if err != nil {
-						return next(c)
-					}
-					reader := io.NopCloser(bytes.NewBuffer(body))
-					key = generateKeyWithBody(c.Request().URL.String()+c.Request().Header.Get(echo.HeaderOrigin), body)
-					c.Request().Body = reader
-				}
-
 				params := c.Request().URL.Query()
-				if _, ok := params[client.refreshKey]; ok {
+				_, refresh := params[client.refreshKey]
+				if refresh {
 					delete(params, client.refreshKey)
-
 					c.Request().URL.RawQuery = params.Encode()
-					key = generateKey(c.Request().URL.String() + c.Request().Header.Get(echo.HeaderOrigin))
+				}
+
+				_, span := client.startSpan(c.Request().Context(), "cache.key")
+				key, ok := client.requestKey(c, p)
+				if ok {
+					span.SetAttributes(Attribute{Key: AttributeKey, Value: KeyAsString(key)})
+				}
+				span.End()
+				if !ok {
+					client.bypass(c)
+					return next(c)
+				}
 
-					if err := client.adapter.Release(key); err != nil {
-						log.Error(err)
+				var stale *Response
+				if refresh {
+					if err := client.release(c.Request().Context(), key); err != nil {
+						client.log().ErrorContext(c.Request().Context(), "cache release failed", "key", KeyAsString(key), "error", err)
 					}
 				} else {
-					b, ok := client.adapter.Get(key)
+					storedKey, response, ok := client.lookup(key, c.Request())
 					if ok {
-						response := BytesToResponse(b)
-						if response.Expiration.After(time.Now()) {
-							response.LastAccess = time.Now()
-							response.Frequency++
-							if err := client.adapter.Set(key, response.Bytes(), response.Expiration); err != nil {
-								log.Error(err)
-							}
-
-							// w.WriteHeader(http.StatusNotModified)
-							for k, v := range response.Header {
-								c.Response().Header().Set(k, strings.Join(v, ","))
-							}
-
-							// Backwards compatibility
-							statusCode := response.StatusCode
-							if statusCode == 0 {
-								statusCode = http.StatusOK
+						now := time.Now()
+						if response.Expiration.After(now) {
+							// a response whose body is gone is a miss
+							if client.openBody(&response) {
+								client.observe(c, ResultHit)
+								client.setCacheStatus(c, cacheStatus{response: &response})
+								return client.serve(c, response)
 							}
+						} else if client.staleWhileRevalidate > 0 && response.Expiration.Add(client.staleWhileRevalidate).After(now) && client.openBody(&response) {
+							client.revalidate(c, next, key, p)
 
-							c.Response().WriteHeader(statusCode)
-							_, err := c.Response().Write(response.Value)
-							return err
-						}
-
-						if err := client.adapter.Release(key); err != nil {
-							log.Error(err)
+							client.observe(c, ResultStale)
+							client.setCacheStatus(c, cacheStatus{response: &response})
+							c.Response().Header().Set("Age", response.age(now))
+							c.Response().Header().Set("Warning", `110 - "Response is Stale"`)
+							return client.serve(c, response)
+						} else if client.staleIfError > 0 && response.Expiration.Add(client.staleIfError).After(now) {
+							stale = &response
+						} else if err := client.adapter.Delete(c.Request().Context(), storedKey); err != nil {
+							client.log().ErrorContext(c.Request().Context(), "cache delete failed", "key", KeyAsString(storedKey), "error", err)
 						}
 					}
 				}
 
-				resBody := new(bytes.Buffer)
-				mw := io.MultiWriter(c.Response().Writer, resBody)
-				writer := &bodyDumpResponseWriter{Writer: mw, ResponseWriter: c.Response().Writer}
-				c.Response().Writer = writer
-
-				err := next(c)
-				if err != nil {
-					c.Error(err)
+				fwd := fwdMiss
+				if refresh {
+					fwd = fwdRequest
+				} else if stale != nil {
+					fwd = fwdStale
 				}
+				client.setCacheStatus(c, cacheStatus{fwd: fwd, policy: &p})
 
-				statusCode := writer.statusCode
-				value := resBody.Bytes()
-				// Cache only non-error responses. For example, timeouts can result in a 200 status with an empty body.
-				if err == nil && statusCode < 400 {
-					now := time.Now()
-
-					response := Response{
-						Value:      value,
-						Header:     writer.Header(),
-						Expiration: now.Add(client.ttl),
-						LastAccess: now,
-						Frequency:  1,
-						StatusCode: statusCode,
-					}
-					if err := client.adapter.Set(key, response.Bytes(), response.Expiration); err != nil {
-						log.Error(err)
-					}
+				if client.coalescing {
+					return client.coalesce(c, next, key, stale, p)
 				}
-				// for k, v := range writer.Header() {
-				//	c.Response().Header().Set(k, strings.Join(v, ","))
-				// }
-				// c.Response().WriteHeader(statusCode)
-				// c.Response().Write(value)
-				return nil
+				_, err := client.fetch(c, next, key, stale, p)
+				return err
 			}
+			client.bypass(c)
 			if err := next(c); err != nil {
 				c.Error(err)
 			}
@@ -223,35 +410,502 @@ func (client *Client) Middleware() echo.MiddlewareFunc {
 	}
 }
 
-func (client *Client) cacheableMethod(method string) bool {
-	for _, m := range client.methods {
-		if method == m {
-			return true
+// fetched is the response of a handler run for a cache miss.
+type fetched struct {
+	// key is the key the response is stored under.
+	key      uint64
+	response Response
+	// stale is true when the handler failed and the expired response
+	// was served instead.
+	stale bool
+}
+
+// fetch runs the handler for a cache miss and caches its response. When an
+// expired response may be served if the handler fails, the handler response
+// is held back. It returns the response that was cached or served stale,
+// nil if there is none.
+func (client *Client) fetch(c echo.Context, next echo.HandlerFunc, key uint64, stale *Response, p Policy) (*fetched, error) {
+	client.setLastModified(c, p)
+	if stale != nil {
+		return client.serveOrFallback(c, next, key, *stale, p)
+	}
+
+	client.observe(c, ResultMiss)
+	if client.streaming {
+		if stream, ok := adapterAs[StreamAdapter](client.adapter); ok {
+			return client.fetchStream(c, next, key, p, stream)
 		}
 	}
-	return false
+
+	resBody := &bodyBuffer{limit: client.maxBodySize}
+	mw := io.MultiWriter(c.Response().Writer, resBody)
+	writer := &bodyDumpResponseWriter{Writer: mw, ResponseWriter: c.Response().Writer, body: resBody}
+	c.Response().Writer = writer
+
+	err := next(c)
+	if err != nil {
+		cacheable := cacheableError(c, err)
+		c.Error(err)
+		if !cacheable {
+			return nil, nil
+		}
+	}
+	if resBody.discarded {
+		return nil, nil
+	}
+
+	// Cache only non-error responses. For example, timeouts can result in a 200 status with an empty body.
+	if storedKey, response, ok := client.store(c, key, writer.statusCode, writer.Header(), resBody.Bytes(), p); ok {
+		return &fetched{key: storedKey, response: response}, nil
+	}
+	return nil, nil
+}
+
+// coalesce runs fetch for only one of the concurrent requests with the same
+// key. The other requests are answered with the cached response once it is
+// available, or run the handler themselves when it turns out not to be
+// cacheable or to be a variant for different request headers.
+func (client *Client) coalesce(c echo.Context, next echo.HandlerFunc, key uint64, stale *Response, p Policy) error {
+	leader := false
+	v, err, _ := client.inflight.Do(KeyAsString(key), func() (any, error) {
+		leader = true
+		return client.fetch(c, next, key, stale, p)
+	})
+	if leader {
+		return err
+	}
+
+	f, _ := v.(*fetched)
+	if f == nil {
+		_, err := client.fetch(c, next, key, stale, p)
+		return err
+	}
+	if f.stale && stale != nil {
+		if !client.openBody(stale) {
+			_, err := client.fetch(c, next, key, nil, p)
+			return err
+		}
+		client.observe(c, ResultStale)
+		client.setCacheStatus(c, cacheStatus{response: stale, fwd: fwdStale})
+		c.Response().Header().Set("Age", stale.age(time.Now()))
+		c.Response().Header().Set("Warning", `111 - "Revalidation Failed"`)
+		return client.serve(c, *stale)
+	}
+	if vary, _ := varyHeaders(f.response.Header); len(vary) > 0 && variantKey(key, vary, c.Request().Header) != f.key {
+		_, err := client.fetch(c, next, key, stale, p)
+		return err
+	}
+	response := f.response
+	if !client.openBody(&response) {
+		_, err := client.fetch(c, next, key, stale, p)
+		return err
+	}
+	client.observe(c, ResultHit)
+	client.setCacheStatus(c, cacheStatus{response: &response})
+	return client.serve(c, response)
 }
 
-// BytesToResponse converts bytes array into Response data structure.
-func BytesToResponse(b []byte) Response {
-	var r Response
-	dec := gob.NewDecoder(bytes.NewReader(b))
-	if err := dec.Decode(&r); err != nil && !errors.Is(err, io.EOF) {
-		log.Error(err)
+// serveOrFallback runs the handler with its response held back and serves
+// the expired cached response instead when the handler fails with a server
+// error, returned or written.
+func (client *Client) serveOrFallback(c echo.Context, next echo.HandlerFunc, key uint64, stale Response, p Policy) (*fetched, error) {
+	original := c.Response().Writer
+	writer := &captureResponseWriter{header: original.Header().Clone(), body: bodyBuffer{limit: client.maxBodySize}, original: original}
+	c.Response().Writer = writer
+
+	err := next(c)
+	if err != nil && !writer.streaming && !serverError(err, writer.statusCode) && cacheableError(c, err) {
+		// recorded as the handler response
+		c.Error(err)
+		err = nil
+	}
+	c.Response().Writer = original
+
+	if writer.streaming {
+		// too large to hold back, it was sent as is
+		client.observe(c, ResultMiss)
+		if err != nil {
+			c.Error(err)
+		}
+		return nil, nil
+	}
+
+	if !serverError(err, writer.statusCode) || !client.openBody(&stale) {
+		client.observe(c, ResultMiss)
+		if cerr := writer.commit(); cerr != nil {
+			return nil, cerr
+		}
+		if err != nil {
+			c.Error(err)
+			return nil, nil
+		}
+		if writer.body.discarded {
+			return nil, nil
+		}
+
+		if storedKey, response, ok := client.store(c, key, writer.statusCode, writer.header, writer.body.Bytes(), p); ok {
+			return &fetched{key: storedKey, response: response}, nil
+		}
+		return nil, nil
+	}
+
+	if err != nil {
+		client.log().ErrorContext(c.Request().Context(), "cache handler failed, serving stale response", "key", KeyAsString(key), "error", err)
 	}
 
-	return r
+	client.observe(c, ResultStale)
+	client.setCacheStatus(c, cacheStatus{response: &stale, fwd: fwdStale, fwdStatus: writer.statusCode})
+	c.Response().Committed = false
+	c.Response().Size = 0
+	c.Response().Header().Set("Age", stale.age(time.Now()))
+	c.Response().Header().Set("Warning", `111 - "Revalidation Failed"`)
+	return &fetched{response: stale, stale: true}, client.serve(c, stale)
 }
 
-// Bytes converts Response data structure into bytes array.
-func (r Response) Bytes() []byte {
-	var b bytes.Buffer
-	enc := gob.NewEncoder(&b)
-	if err := enc.Encode(&r); err != nil {
-		log.Error(err)
+// serverError reports whether a handler failed with a server error, either
+// returned, as an error other than an HTTP error below 500, or written.
+func serverError(err error, statusCode int) bool {
+	if statusCode >= 500 {
+		return true
+	}
+	if err == nil {
+		return false
+	}
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return he.Code >= 500
+	}
+	return true
+}
+
+// cacheableError reports whether an error returned by the handler is
+// written as a response that may be cached, like a written response
+// depending on its status code. It must be called before the error is
+// written: HTTP errors such as echo.ErrNotFound are, unless the handler
+// wrote a response already.
+func cacheableError(c echo.Context, err error) bool {
+	var he *echo.HTTPError
+	return errors.As(err, &he) && !c.Response().Committed
+}
+
+// serve writes a cached response.
+func (client *Client) serve(c echo.Context, response Response) error {
+	if response.body != nil {
+		defer response.body.Close()
+	}
+	header, value := response.Header, response.Value
+	if response.Encoding != "" {
+		var err error
+		if header, value, err = client.encodedHeader(c.Request(), response); err != nil {
+			return err
+		}
+	}
+
+	// Backwards compatibility
+	statusCode := response.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+
+	// preconditions are only evaluated for successful responses, RFC 9110
+	// section 13.2.1
+	if client.conditional && statusCode >= 200 && statusCode < 300 && notModified(c.Request(), header) {
+		for _, k := range notModifiedHeaders {
+			if v, ok := header[k]; ok {
+				c.Response().Header().Set(k, strings.Join(v, ","))
+			}
+		}
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	for k, v := range header {
+		c.Response().Header().Set(k, strings.Join(v, ","))
+	}
+
+	c.Response().WriteHeader(statusCode)
+	if response.body != nil {
+		_, err := io.Copy(c.Response(), response.body)
+		return err
+	}
+	_, err := c.Response().Write(value)
+	return err
+}
+
+// store caches a handler response for a request key, unless its status code
+// or headers make it uncacheable. It returns the cached response and the key
+// it is stored under.
+func (client *Client) store(c echo.Context, key uint64, statusCode int, header http.Header, value []byte, p Policy) (uint64, Response, bool) {
+	return client.storeBody(c, key, statusCode, header, storedBody{value: value, hash: hashBody(value), size: len(value)}, p)
+}
+
+// storedBody is the body of a response being cached. A streamed body is
+// already stored with the stream adapter.
+type storedBody struct {
+	value    []byte
+	hash     uint64
+	size     int
+	streamed bool
+}
+
+// storeBody caches a handler response with a given body.
+func (client *Client) storeBody(c echo.Context, key uint64, statusCode int, header http.Header, body storedBody, p Policy) (uint64, Response, bool) {
+	now := time.Now()
+	ttl, ok := client.storeTTL(p, statusCode, header, now)
+	if !ok {
+		return 0, Response{}, false
+	}
+	vary, _ := varyHeaders(header)
+
+	header = header.Clone()
+	client.stripCacheStatus(header)
+	if client.conditional {
+		setValidators(header, body.hash, now)
+	}
+
+	response := Response{
+		Value:      body.value,
+		Header:     header,
+		Expiration: now.Add(ttl),
+		LastAccess: now,
+		Frequency:  1,
+		StatusCode: statusCode,
+		Created:    now,
+	}
+	if client.pathIndex {
+		response.Path = path.Clean("/" + c.Request().URL.Path)
+	}
+	// the response has been sent already, it is stored even if the client
+	// has gone away in the meantime
+	ctx := context.WithoutCancel(c.Request().Context())
+	expiration := response.Expiration.Add(client.grace())
+	storedKey := key
+	if len(vary) > 0 {
+		storedKey = variantKey(key, vary, c.Request().Header)
+	}
+	if body.streamed {
+		response.StreamKey = streamKey(storedKey)
+	} else {
+		client.compress(&response)
+	}
+	if len(vary) > 0 {
+		index := Response{
+			Expiration: response.Expiration,
+			LastAccess: now,
+			Created:    now,
+			Vary:       vary,
+			Path:       response.Path,
+		}
+		variant := storedKey
+		if err := client.adapter.SetMulti(ctx, []Entry{
+			{Key: key, Value: index.Bytes(), Expiration: expiration},
+			{Key: variant, Value: response.Bytes(), Expiration: expiration},
+		}); err != nil {
+			client.log().ErrorContext(ctx, "cache set failed", "key", KeyAsString(key), "variant", KeyAsString(variant), "error", err)
+		}
+		client.addToIndex(ctx, varyIndexName(key), variant, expiration)
+		key = variant
+	} else {
+		client.set(ctx, key, response)
+	}
+	for _, tag := range responseTags(c, header) {
+		client.addToIndex(ctx, "tag:"+tag, key, expiration)
+	}
+	if client.pathIndex {
+		client.addToPathIndex(ctx, response.Path, key, expiration)
+	}
+
+	if client.metrics != nil {
+		size := body.size
+		if !body.streamed {
+			// compressed, if at all
+			size = len(response.Value)
+		}
+		client.metrics.Stored(c.Path(), size)
 	}
 
-	return b.Bytes()
+	return key, response, true
+}
+
+// storeTTL returns how long a handler response is cached. It returns false
+// when the response must not be cached.
+func (client *Client) storeTTL(p Policy, statusCode int, header http.Header, now time.Time) (time.Duration, bool) {
+	if !p.cacheableStatus(statusCode) {
+		return 0, false
+	}
+
+	ttl, cacheable := p.ttl(statusCode), true
+	if client.cacheControl {
+		ttl, cacheable = responseTTL(header, now, ttl)
+	}
+	if _, ok := varyHeaders(header); !cacheable || !ok {
+		return 0, false
+	}
+	return ttl, true
+}
+
+// set writes a response to the adapter. Entries are kept past the response
+// expiration for as long as they may still be served stale.
+func (client *Client) set(ctx context.Context, key uint64, response Response) {
+	if err := client.adapter.Set(ctx, key, response.Bytes(), response.Expiration.Add(client.grace())); err != nil {
+		client.log().ErrorContext(ctx, "cache set failed", "key", KeyAsString(key), "error", err)
+	}
+}
+
+// grace returns how long entries are kept past their expiration to be
+// served stale.
+func (client *Client) grace() time.Duration {
+	return max(client.staleWhileRevalidate, client.staleIfError)
+}
+
+// revalidate refreshes a cached response in the background by running the
+// handler with a copy of the request. Only one refresh per key runs at a
+// time. Values set on the context by preceding middlewares are not
+// available to the handler.
+func (client *Client) revalidate(c echo.Context, next echo.HandlerFunc, key uint64, p Policy) {
+	if _, loaded := client.revalidating.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+
+	// the refresh outlives the request, but keeps its values, such as the
+	// span it is traced under
+	r := c.Request().Clone(context.WithoutCancel(c.Request().Context()))
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			client.revalidating.Delete(key)
+			client.log().ErrorContext(c.Request().Context(), "cache revalidation failed", "key", KeyAsString(key), "error", err)
+			return
+		}
+		c.Request().Body = io.NopCloser(bytes.NewReader(body))
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	writer := &captureResponseWriter{header: http.Header{}, body: bodyBuffer{limit: client.maxBodySize}}
+	bc := c.Echo().NewContext(r, writer)
+	bc.SetPath(c.Path())
+	bc.SetParamNames(c.ParamNames()...)
+	bc.SetParamValues(c.ParamValues()...)
+
+	go func() {
+		defer client.revalidating.Delete(key)
+		defer func() {
+			if p := recover(); p != nil {
+				client.log().Error("cache revalidation panic", "key", KeyAsString(key), "panic", p)
+			}
+		}()
+
+		ctx, span := client.startSpan(r.Context(), "cache.revalidate")
+		defer span.End()
+		span.SetAttributes(Attribute{Key: AttributeKey, Value: KeyAsString(key)})
+		bc.SetRequest(r.WithContext(ctx))
+
+		if err := next(bc); err != nil {
+			span.RecordError(err)
+			client.log().ErrorContext(ctx, "cache revalidation failed", "key", KeyAsString(key), "error", err)
+			return
+		}
+		if writer.body.discarded {
+			return
+		}
+		span.SetAttributes(Attribute{Key: AttributeSize, Value: writer.body.Len()})
+		client.store(bc, key, writer.statusCode, writer.header, writer.body.Bytes(), p)
+	}()
+}
+
+// lookup retrieves the cached response for a request key. When the entry
+// stored under the key is a Vary index, the response variant matching the
+// request headers is retrieved instead. The key the returned response is
+// stored under is returned as well.
+func (client *Client) lookup(key uint64, r *http.Request) (uint64, Response, bool) {
+	b, ok := client.get(r.Context(), key)
+	if !ok {
+		return key, Response{}, false
+	}
+
+	response, ok := client.decode(r.Context(), key, b)
+	if !ok || len(response.Vary) == 0 || !response.Expiration.Add(client.grace()).After(time.Now()) {
+		return key, response, ok
+	}
+
+	key = variantKey(key, response.Vary, r.Header)
+	b, ok = client.get(r.Context(), key)
+	if !ok {
+		return key, Response{}, false
+	}
+
+	response, ok = client.decode(r.Context(), key, b)
+	return key, response, ok
+}
+
+// get reads an entry from the adapter. Adapter failures are logged and
+// handled as a cache miss.
+func (client *Client) get(ctx context.Context, key uint64) ([]byte, bool) {
+	b, err := client.adapter.Get(ctx, key)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			client.log().ErrorContext(ctx, "cache get failed", "key", KeyAsString(key), "error", err)
+		}
+		return nil, false
+	}
+	return b, true
+}
+
+// decode decodes an entry read from the adapter. Invalid entries are logged
+// and handled as a cache miss.
+func (client *Client) decode(ctx context.Context, key uint64, b []byte) (Response, bool) {
+	response, err := DecodeResponse(b)
+	if err != nil {
+		client.log().ErrorContext(ctx, "cache response decoding failed", "key", KeyAsString(key), "error", err)
+		return Response{}, false
+	}
+	return response, true
+}
+
+// requestKey returns the cache key of a request. It returns false when the
+// request must not be cached.
+func (client *Client) requestKey(c echo.Context, p Policy) (uint64, bool) {
+	if client.keyFunc != nil {
+		return client.keyFunc(c)
+	}
+
+	r := c.Request()
+	if r.Method == http.MethodPost && r.Body != nil {
+		body, err := io.ReadAll(r.Body)
+		_ = r.Body.Close()
+		if err != nil {
+			return 0, false
+		}
+		r.Body = io.NopCloser(bytes.NewBuffer(body))
+		return generateKeyWithBody(keyURL(r, p.KeyHeaders), body), true
+	}
+
+	return generateKey(keyURL(r, p.KeyHeaders)), true
+}
+
+// keyURL returns the request data the cache key is generated from.
+func keyURL(r *http.Request, headers []string) string {
+	URL := r.URL.String() + r.Header.Get(echo.HeaderOrigin)
+	for _, h := range headers {
+		URL += "\n" + h + ":" + strings.Join(r.Header.Values(h), ",")
+	}
+	return URL
+}
+
+func (client *Client) cacheableMethod(method string) bool {
+	for _, m := range client.methods {
+		if method == m {
+			return true
+		}
+	}
+	return false
+}
+
+// age returns the Age header value of a cached response.
+func (r Response) age(now time.Time) string {
+	if r.Created.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(max(int64(now.Sub(r.Created)/time.Second), 0), 10)
 }
 
 func sortURLParams(URL *url.URL) {
@@ -304,6 +958,21 @@ func NewClient(opts ...ClientOption) (*Client, error) {
 	if c.methods == nil {
 		c.methods = []string{http.MethodGet}
 	}
+	if _, ok := adapterAs[StreamAdapter](c.adapter); c.streaming && !ok {
+		return nil, errors.New("cache client adapter does not support streaming")
+	}
+	if c.pathIndex && !pathIndexable(c.adapter) {
+		return nil, errors.New("cache client adapter does not support the path index")
+	}
+	if c.metrics != nil {
+		c.adapter = &instrumentedAdapter{adapter: c.adapter, metrics: c.metrics}
+	}
+	if c.tracer != nil {
+		c.adapter = newTracedAdapter(c.adapter, c.tracer)
+	}
+	if err := c.subscribe(); err != nil {
+		return nil, err
+	}
 
 	return c, nil
 }
@@ -311,6 +980,34 @@ func NewClient(opts ...ClientOption) (*Client, error) {
 // ClientWithAdapter sets the adapter type for the HTTP cache
 // middleware client.
 func ClientWithAdapter(a Adapter) ClientOption {
+	return func(c *Client) error {
+		if a != nil {
+			c.adapter = WrapAdapter(a)
+		}
+		return nil
+	}
+}
+
+// ClientWithLogger sets the logger of the HTTP cache middleware client.
+// Defaults to slog.Default().
+func ClientWithLogger(l *slog.Logger) ClientOption {
+	return func(c *Client) error {
+		c.logger = l
+		return nil
+	}
+}
+
+// log returns the client logger.
+func (client *Client) log() *slog.Logger {
+	if client.logger == nil {
+		return slog.Default()
+	}
+	return client.logger
+}
+
+// ClientWithAdapterV2 sets the context aware adapter type for the HTTP
+// cache middleware client. It is used instead of ClientWithAdapter.
+func ClientWithAdapterV2(a AdapterV2) ClientOption {
 	return func(c *Client) error {
 		c.adapter = a
 		return nil
@@ -361,3 +1058,168 @@ func ClientWithRestrictedPaths(paths []string) ClientOption {
 		return nil
 	}
 }
+
+// ClientWithCacheControl sets whether the Cache-Control and Expires headers
+// set by the handler decide if and for how long a response is cached.
+// Responses marked no-store, no-cache or private are not cached, s-maxage,
+// max-age and Expires override the client ttl. The client ttl is used when
+// the handler sets none of them. Optional setting.
+func ClientWithCacheControl(enabled bool) ClientOption {
+	return func(c *Client) error {
+		c.cacheControl = enabled
+		return nil
+	}
+}
+
+// ClientWithConditionalRequests sets whether cached responses carry ETag and
+// Last-Modified validators and whether matching If-None-Match and
+// If-Modified-Since requests are answered from cache with a 304 status,
+// for successful responses only. Validators set by the handler are
+// preserved. The response of a cache miss carries the Last-Modified date,
+// the ETag computed from the body is sent from the first hit on. Optional
+// setting.
+func ClientWithConditionalRequests(enabled bool) ClientOption {
+	return func(c *Client) error {
+		c.conditional = enabled
+		return nil
+	}
+}
+
+// ClientWithKeyHeaders sets request headers whose values are part of the
+// cache key of every cached response, regardless of the response Vary
+// header. Optional setting.
+func ClientWithKeyHeaders(headers []string) ClientOption {
+	return func(c *Client) error {
+		c.keyHeaders = canonicalHeaders(headers)
+		return nil
+	}
+}
+
+// ClientWithKeyFunc sets a custom cache key generator replacing the default
+// one, which hashes the request URL, Origin header, key headers and, for
+// POST requests, the request body. The refresh key parameter is removed
+// from the request URL before the function is called. Vary headers of
+// cached responses still apply on top of the returned key.
+// Optional setting.
+func ClientWithKeyFunc(fn KeyFunc) ClientOption {
+	return func(c *Client) error {
+		c.keyFunc = fn
+		return nil
+	}
+}
+
+// ClientWithStaleWhileRevalidate sets how long after expiration a cached
+// response is still served while it is refreshed in the background. Only
+// one background refresh runs per cached response. Optional setting.
+func ClientWithStaleWhileRevalidate(d time.Duration) ClientOption {
+	return func(c *Client) error {
+		if d < 0 {
+			return fmt.Errorf("cache client stale while revalidate %v is invalid", d)
+		}
+
+		c.staleWhileRevalidate = d
+
+		return nil
+	}
+}
+
+// ClientWithStaleIfError sets how long after expiration a cached response is
+// still kept to be served when the handler returns an error or writes a 5xx
+// status. HTTP errors below 500, such as echo.ErrNotFound, are not handled as
+// failures. While an expired response can be served this way, the handler response
+// is buffered instead of being streamed to the client. Optional setting.
+func ClientWithStaleIfError(d time.Duration) ClientOption {
+	return func(c *Client) error {
+		if d < 0 {
+			return fmt.Errorf("cache client stale if error %v is invalid", d)
+		}
+
+		c.staleIfError = d
+
+		return nil
+	}
+}
+
+// ClientWithCoalescing sets whether concurrent cache misses for the same key
+// are coalesced, so that the handler runs only once and the other requests
+// are answered with its cached response. Optional setting.
+func ClientWithCoalescing(enabled bool) ClientOption {
+	return func(c *Client) error {
+		c.coalescing = enabled
+		return nil
+	}
+}
+
+// ClientWithPolicy sets a policy overriding the client settings for the
+// route with the given path, as registered in echo, or name.
+// Optional setting.
+func ClientWithPolicy(route string, p Policy) ClientOption {
+	return func(c *Client) error {
+		if p.TTL < 0 {
+			return fmt.Errorf("cache client policy %s ttl %v is invalid", route, p.TTL)
+		}
+		if c.policies == nil {
+			c.policies = make(map[string]Policy)
+		}
+		c.policies[route] = p
+		if p.KeyHeaders != nil {
+			c.policyKeyHeaders.Store(true)
+		}
+		return nil
+	}
+}
+
+// ClientWithStatusCodes sets the cacheable response status codes.
+// Optional setting. If not set, responses with a status code below 400
+// are cached. The responses of HTTP errors returned by handlers, such as
+// echo.ErrNotFound, are cached like written responses.
+func ClientWithStatusCodes(codes []int) ClientOption {
+	return func(c *Client) error {
+		for _, code := range codes {
+			if code < 100 || code > 999 {
+				return fmt.Errorf("invalid status code %d", code)
+			}
+		}
+		c.statusCodes = codes
+		return nil
+	}
+}
+
+// ClientWithStatusFunc sets a function reporting whether a response with a
+// given status code may be cached. It takes precedence over
+// ClientWithStatusCodes. Optional setting.
+func ClientWithStatusFunc(fn func(statusCode int) bool) ClientOption {
+	return func(c *Client) error {
+		c.statusFunc = fn
+		return nil
+	}
+}
+
+// ClientWithNegativeTTL sets how long 404 and 410 responses are going to be
+// cached, when their status codes are made cacheable with
+// ClientWithStatusCodes or ClientWithStatusFunc. Optional setting. If not
+// set, the client ttl is used.
+func ClientWithNegativeTTL(ttl time.Duration) ClientOption {
+	return func(c *Client) error {
+		if int64(ttl) < 1 {
+			return fmt.Errorf("cache client negative ttl %v is invalid", ttl)
+		}
+
+		c.negativeTTL = ttl
+
+		return nil
+	}
+}
+
+// ClientWithPathIndex sets whether cached responses are indexed by their URL
+// path and its parent paths, which Client.InvalidatePrefix requires. With
+// an adapter implementing Indexer, each stored response is added to one set
+// per path segment. Otherwise, the adapter must implement Lister, the path
+// is stored with each response and InvalidatePrefix reads all entries.
+// Optional setting.
+func ClientWithPathIndex(enabled bool) ClientOption {
+	return func(c *Client) error {
+		c.pathIndex = enabled
+		return nil
+	}
+}
@@ -2,16 +2,23 @@ package cache
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/coinpaprika/echo-http-cache/adapter/disk"
 	"github.com/coinpaprika/echo-http-cache/adapter/memory"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
@@ -537,7 +544,7 @@ func TestNewClient(t *testing.T) {
 				ClientWithMethods([]string{http.MethodGet, http.MethodPost}),
 			},
 			&Client{
-				adapter:    adapter,
+				adapter:    WrapAdapter(adapter),
 				ttl:        1 * time.Millisecond,
 				refreshKey: "",
 				methods:    []string{http.MethodGet, http.MethodPost},
@@ -552,7 +559,7 @@ func TestNewClient(t *testing.T) {
 				ClientWithRefreshKey("rk"),
 			},
 			&Client{
-				adapter:    adapter,
+				adapter:    WrapAdapter(adapter),
 				ttl:        1 * time.Millisecond,
 				refreshKey: "rk",
 				methods:    []string{http.MethodGet},
@@ -610,3 +617,1088 @@ func TestNewClient(t *testing.T) {
 		})
 	}
 }
+
+func TestCacheControl(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     http.Header
+		wantStored bool
+		wantTTL    time.Duration
+	}{
+		{
+			name:       "falls back to client ttl",
+			header:     http.Header{},
+			wantStored: true,
+			wantTTL:    time.Minute,
+		},
+		{
+			name:       "no-store is not cached",
+			header:     http.Header{"Cache-Control": []string{"no-store"}},
+			wantStored: false,
+		},
+		{
+			name:       "private is not cached",
+			header:     http.Header{"Cache-Control": []string{"private, max-age=30"}},
+			wantStored: false,
+		},
+		{
+			name:       "max-age overrides client ttl",
+			header:     http.Header{"Cache-Control": []string{"public, max-age=30"}},
+			wantStored: true,
+			wantTTL:    30 * time.Second,
+		},
+		{
+			name:       "s-maxage takes precedence over max-age",
+			header:     http.Header{"Cache-Control": []string{"max-age=30, s-maxage=10"}},
+			wantStored: true,
+			wantTTL:    10 * time.Second,
+		},
+		{
+			name:       "max-age=0 is not cached",
+			header:     http.Header{"Cache-Control": []string{"max-age=0"}},
+			wantStored: false,
+		},
+		{
+			name: "expires relative to date",
+			header: http.Header{
+				"Date":    []string{"Mon, 02 Jan 2006 15:04:05 GMT"},
+				"Expires": []string{"Mon, 02 Jan 2006 15:06:05 GMT"},
+			},
+			wantStored: true,
+			wantTTL:    2 * time.Minute,
+		},
+		{
+			name:       "invalid expires is not cached",
+			header:     http.Header{"Expires": []string{"0"}},
+			wantStored: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			adapter := &adapterMock{
+				store: map[uint64][]byte{},
+			}
+
+			client, err := NewClient(
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1*time.Minute),
+				ClientWithCacheControl(true),
+			)
+			require.NoError(t, err)
+
+			handler := func(c echo.Context) error {
+				for k, v := range tt.header {
+					c.Response().Header()[k] = v
+				}
+				return c.String(http.StatusOK, "ok")
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+			rec := httptest.NewRecorder()
+			before := time.Now()
+			require.NoError(t, client.Middleware()(handler)(e.NewContext(req, rec)))
+
+			if !tt.wantStored {
+				assert.Len(t, adapter.store, 0)
+				return
+			}
+			require.Len(t, adapter.store, 1)
+			for _, b := range adapter.store {
+				expiration := BytesToResponse(b).Expiration
+				assert.WithinDuration(t, before.Add(tt.wantTTL), expiration, time.Second)
+			}
+		})
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	e := echo.New()
+	adapter := &adapterMock{
+		store: map[uint64][]byte{},
+	}
+
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithConditionalRequests(true),
+		ClientWithStatusCodes([]int{http.StatusOK, http.StatusNotFound}),
+	)
+	require.NoError(t, err)
+
+	handler := func(c echo.Context) error {
+		switch c.Request().URL.Path {
+		case "/tagged":
+			c.Response().Header().Set("ETag", `"v1"`)
+		case "/missing":
+			return c.String(http.StatusNotFound, "missing")
+		}
+		return c.String(http.StatusOK, "ok")
+	}
+	middleware := client.Middleware()
+
+	serve := func(url string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		require.NoError(t, middleware(handler)(e.NewContext(req, rec)))
+		return rec
+	}
+
+	// populate cache
+	rec := serve("http://foo.bar/test", nil)
+	lastModified := rec.Header().Get("Last-Modified")
+	require.NotEmpty(t, lastModified, "sent on a miss")
+	assert.Empty(t, rec.Header().Get("ETag"), "computed from the complete body")
+	rec = serve("http://foo.bar/test", nil)
+	etag := rec.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	require.Equal(t, lastModified, rec.Header().Get("Last-Modified"))
+
+	tests := []struct {
+		name     string
+		url      string
+		header   http.Header
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "matching etag",
+			url:      "http://foo.bar/test",
+			header:   http.Header{"If-None-Match": []string{`"other", ` + etag}},
+			wantCode: http.StatusNotModified,
+		},
+		{
+			name:     "weak matching etag",
+			url:      "http://foo.bar/test",
+			header:   http.Header{"If-None-Match": []string{"W/" + etag}},
+			wantCode: http.StatusNotModified,
+		},
+		{
+			name:     "etag mismatch",
+			url:      "http://foo.bar/test",
+			header:   http.Header{"If-None-Match": []string{`"other"`}},
+			wantCode: http.StatusOK,
+			wantBody: "ok",
+		},
+		{
+			name:     "not modified since",
+			url:      "http://foo.bar/test",
+			header:   http.Header{"If-Modified-Since": []string{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}},
+			wantCode: http.StatusNotModified,
+		},
+		{
+			name:     "modified since",
+			url:      "http://foo.bar/test",
+			header:   http.Header{"If-Modified-Since": []string{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)}},
+			wantCode: http.StatusOK,
+			wantBody: "ok",
+		},
+		{
+			name:     "handler etag is preserved",
+			url:      "http://foo.bar/tagged",
+			header:   http.Header{"If-None-Match": []string{`"v1"`}},
+			wantCode: http.StatusNotModified,
+		},
+		{
+			name:     "not found matching any etag",
+			url:      "http://foo.bar/missing",
+			header:   http.Header{"If-None-Match": []string{"*"}},
+			wantCode: http.StatusNotFound,
+			wantBody: "missing",
+		},
+		{
+			name:     "not found not modified since",
+			url:      "http://foo.bar/missing",
+			header:   http.Header{"If-Modified-Since": []string{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}},
+			wantCode: http.StatusNotFound,
+			wantBody: "missing",
+		},
+	}
+
+	serve("http://foo.bar/tagged", nil)
+	serve("http://foo.bar/missing", nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(tt.url, tt.header)
+			assert.Equal(t, tt.wantCode, rec.Code)
+			assert.Equal(t, tt.wantBody, rec.Body.String())
+		})
+	}
+}
+
+func TestVary(t *testing.T) {
+	e := echo.New()
+	adapter := &adapterMock{
+		store: map[uint64][]byte{},
+	}
+
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithRefreshKey("rk"),
+	)
+	require.NoError(t, err)
+
+	calls := 0
+	handler := func(c echo.Context) error {
+		calls++
+		if c.Request().URL.Path == "/any" {
+			c.Response().Header().Set("Vary", "*")
+		} else {
+			c.Response().Header().Set("Vary", "Accept-Language")
+		}
+		return c.String(http.StatusOK, fmt.Sprintf("%s %d", c.Request().Header.Get("Accept-Language"), calls))
+	}
+	middleware := client.Middleware()
+
+	tests := []struct {
+		name      string
+		url       string
+		language  string
+		wantBody  string
+		wantCalls int
+	}{
+		{"stores first variant", "http://foo.bar/test", "en", "en 1", 1},
+		{"stores second variant", "http://foo.bar/test", "de", "de 2", 2},
+		{"returns first variant", "http://foo.bar/test", "en", "en 1", 2},
+		{"returns second variant", "http://foo.bar/test", "de", "de 2", 2},
+		{"refreshes variant", "http://foo.bar/test?rk=true", "de", "de 3", 3},
+		{"returns refreshed variant", "http://foo.bar/test", "de", "de 3", 3},
+		{"does not cache vary *", "http://foo.bar/any", "en", "en 4", 4},
+		{"does not return vary *", "http://foo.bar/any", "en", "en 5", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			req.Header.Set("Accept-Language", tt.language)
+			rec := httptest.NewRecorder()
+			require.NoError(t, middleware(handler)(e.NewContext(req, rec)))
+
+			assert.Equal(t, tt.wantBody, rec.Body.String())
+			assert.Equal(t, tt.wantCalls, calls)
+		})
+	}
+}
+
+func TestKeyHeaders(t *testing.T) {
+	e := echo.New()
+	adapter := &adapterMock{
+		store: map[uint64][]byte{},
+	}
+
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithKeyHeaders([]string{"authorization"}),
+	)
+	require.NoError(t, err)
+
+	handler := func(c echo.Context) error {
+		return c.String(http.StatusOK, c.Request().Header.Get(echo.HeaderAuthorization))
+	}
+	middleware := client.Middleware()
+
+	for _, token := range []string{"a", "b", "a"} {
+		req := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+		req.Header.Set(echo.HeaderAuthorization, token)
+		rec := httptest.NewRecorder()
+		require.NoError(t, middleware(handler)(e.NewContext(req, rec)))
+
+		assert.Equal(t, token, rec.Body.String())
+	}
+	assert.Len(t, adapter.store, 2)
+}
+
+func TestKeyFunc(t *testing.T) {
+	adapter := &adapterMock{
+		store: map[uint64][]byte{},
+	}
+
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithKeyFunc(func(c echo.Context) (uint64, bool) {
+			tenant, ok := c.Get("tenant").(string)
+			if !ok {
+				return 0, false
+			}
+			return generateKey(tenant + c.Path() + c.Param("id")), true
+		}),
+	)
+	require.NoError(t, err)
+
+	calls := 0
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if tenant := c.Request().Header.Get("X-Tenant"); tenant != "" {
+				c.Set("tenant", tenant)
+			}
+			return next(c)
+		}
+	})
+	e.Use(client.Middleware())
+	e.GET("/coins/:id", func(c echo.Context) error {
+		calls++
+		return c.String(http.StatusOK, fmt.Sprintf("%s %d", c.Param("id"), calls))
+	})
+
+	tests := []struct {
+		name     string
+		url      string
+		tenant   string
+		wantBody string
+	}{
+		{"stores response", "/coins/btc?foo=bar", "a", "btc 1"},
+		{"ignores query string", "/coins/btc?foo=baz", "a", "btc 1"},
+		{"keys by tenant", "/coins/btc", "b", "btc 2"},
+		{"keys by param", "/coins/eth", "a", "eth 3"},
+		{"opts out of caching", "/coins/btc", "", "btc 4"},
+		{"opts out of caching", "/coins/btc", "", "btc 5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.tenant != "" {
+				req.Header.Set("X-Tenant", tt.tenant)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantBody, rec.Body.String())
+		})
+	}
+	assert.Len(t, adapter.store, 3)
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	e := echo.New()
+	adapter := &adapterMock{
+		store: map[uint64][]byte{},
+	}
+
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(100*time.Millisecond),
+		ClientWithStaleWhileRevalidate(1*time.Minute),
+	)
+	require.NoError(t, err)
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	handler := func(c echo.Context) error {
+		n := calls.Add(1)
+		if n > 1 {
+			<-release
+		}
+		return c.String(http.StatusOK, fmt.Sprintf("value %d", n))
+	}
+	middleware := client.Middleware()
+
+	serve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+		rec := httptest.NewRecorder()
+		require.NoError(t, middleware(handler)(e.NewContext(req, rec)))
+		return rec
+	}
+
+	assert.Equal(t, "value 1", serve().Body.String())
+	time.Sleep(150 * time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		rec := serve()
+		assert.Equal(t, "value 1", rec.Body.String())
+		assert.NotEmpty(t, rec.Header().Get("Warning"))
+		assert.NotEmpty(t, rec.Header().Get("Age"))
+	}
+	close(release)
+
+	assert.Eventually(t, func() bool {
+		b, _ := adapter.Get(generateKey("http://foo.bar/test"))
+		return string(BytesToResponse(b).Value) == "value 2"
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestStaleIfError(t *testing.T) {
+	e := echo.New()
+	adapter := &adapterMock{
+		store: map[uint64][]byte{},
+	}
+
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(100*time.Millisecond),
+		ClientWithStaleIfError(1*time.Minute),
+	)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		handler     echo.HandlerFunc
+		wantCode    int
+		wantBody    string
+		wantWarning bool
+	}{
+		{
+			name: "stores response",
+			handler: func(c echo.Context) error {
+				return c.String(http.StatusOK, "value 1")
+			},
+			wantCode: http.StatusOK,
+			wantBody: "value 1",
+		},
+		{
+			name: "serves stale response on error",
+			handler: func(_ echo.Context) error {
+				return errors.New("upstream failure")
+			},
+			wantCode:    http.StatusOK,
+			wantBody:    "value 1",
+			wantWarning: true,
+		},
+		{
+			name: "serves stale response on server error status",
+			handler: func(c echo.Context) error {
+				return c.String(http.StatusBadGateway, "bad gateway")
+			},
+			wantCode:    http.StatusOK,
+			wantBody:    "value 1",
+			wantWarning: true,
+		},
+		{
+			name: "serves stale response on server http error",
+			handler: func(_ echo.Context) error {
+				return echo.NewHTTPError(http.StatusServiceUnavailable)
+			},
+			wantCode:    http.StatusOK,
+			wantBody:    "value 1",
+			wantWarning: true,
+		},
+		{
+			name: "does not serve stale response on client http error",
+			handler: func(_ echo.Context) error {
+				return echo.ErrNotFound
+			},
+			wantCode: http.StatusNotFound,
+			wantBody: "{\"message\":\"Not Found\"}\n",
+		},
+		{
+			name: "serves new response",
+			handler: func(c echo.Context) error {
+				return c.String(http.StatusOK, "value 2")
+			},
+			wantCode: http.StatusOK,
+			wantBody: "value 2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+			rec := httptest.NewRecorder()
+			require.NoError(t, client.Middleware()(tt.handler)(e.NewContext(req, rec)))
+
+			assert.Equal(t, tt.wantCode, rec.Code)
+			assert.Equal(t, tt.wantBody, rec.Body.String())
+			assert.Equal(t, tt.wantWarning, rec.Header().Get("Warning") != "")
+			time.Sleep(150 * time.Millisecond)
+		})
+	}
+}
+
+func TestCoalescing(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		wantCalls int32
+	}{
+		{"shares cached response", http.StatusOK, 1},
+		{"runs handler for uncacheable response", http.StatusInternalServerError, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			adapter := &adapterMock{
+				store: map[uint64][]byte{},
+			}
+
+			client, err := NewClient(
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1*time.Minute),
+				ClientWithCoalescing(true),
+			)
+			require.NoError(t, err)
+
+			var calls atomic.Int32
+			release := make(chan struct{})
+			handler := func(c echo.Context) error {
+				calls.Add(1)
+				<-release
+				c.Response().Header().Set("X-Value", "value")
+				return c.String(tt.status, "value")
+			}
+			middleware := client.Middleware()
+
+			var wg sync.WaitGroup
+			recs := make([]*httptest.ResponseRecorder, 5)
+			for i := range recs {
+				recs[i] = httptest.NewRecorder()
+				wg.Add(1)
+				go func(rec *httptest.ResponseRecorder) {
+					defer wg.Done()
+					req := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+					assert.NoError(t, middleware(handler)(e.NewContext(req, rec)))
+				}(recs[i])
+			}
+
+			assert.Eventually(t, func() bool {
+				return calls.Load() == 1
+			}, time.Second, time.Millisecond)
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			assert.Equal(t, tt.wantCalls, calls.Load())
+			for _, rec := range recs {
+				assert.Equal(t, tt.status, rec.Code)
+				assert.Equal(t, "value", rec.Body.String())
+				assert.Equal(t, "value", rec.Header().Get("X-Value"))
+			}
+		})
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	adapter := &adapterMock{
+		store: map[uint64][]byte{},
+	}
+
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithPolicy("/short", Policy{TTL: 10 * time.Second}),
+		ClientWithPolicy("uncached", Policy{Disabled: true}),
+	)
+	require.NoError(t, err)
+
+	e := echo.New()
+	e.Use(client.Middleware())
+	handler := func(c echo.Context) error {
+		return c.String(http.StatusOK, "ok")
+	}
+	e.GET("/default", handler)
+	e.GET("/short", handler)
+	e.GET("/coins/:id", handler).Name = "uncached"
+
+	g := e.Group("/missing")
+	g.GET("", func(c echo.Context) error {
+		return c.String(http.StatusNotFound, "not found")
+	}, client.MiddlewareWithPolicy(Policy{StatusCodes: []int{http.StatusNotFound}, TTL: 5 * time.Second}))
+
+	tests := []struct {
+		name       string
+		url        string
+		wantStored bool
+		wantTTL    time.Duration
+	}{
+		{"client settings", "/default", true, time.Minute},
+		{"route path policy", "/short", true, 10 * time.Second},
+		{"route name policy", "/coins/btc", false, 0},
+		{"middleware policy", "/missing", true, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter.store = map[uint64][]byte{}
+
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			rec := httptest.NewRecorder()
+			before := time.Now()
+			e.ServeHTTP(rec, req)
+
+			if !tt.wantStored {
+				assert.Len(t, adapter.store, 0)
+				return
+			}
+			require.NotEmpty(t, adapter.store)
+			for _, b := range adapter.store {
+				expiration := BytesToResponse(b).Expiration
+				assert.WithinDuration(t, before.Add(tt.wantTTL), expiration, time.Second)
+			}
+		})
+	}
+}
+
+func TestStatusCodes(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []ClientOption
+		status     int
+		wantStored bool
+		wantTTL    time.Duration
+	}{
+		{
+			name:       "caches redirects by default",
+			status:     http.StatusFound,
+			wantStored: true,
+			wantTTL:    time.Minute,
+		},
+		{
+			name:       "does not cache not found by default",
+			status:     http.StatusNotFound,
+			wantStored: false,
+		},
+		{
+			name:       "status code list excludes redirects",
+			opts:       []ClientOption{ClientWithStatusCodes([]int{http.StatusOK, http.StatusNotFound})},
+			status:     http.StatusFound,
+			wantStored: false,
+		},
+		{
+			name: "status code list with negative ttl",
+			opts: []ClientOption{
+				ClientWithStatusCodes([]int{http.StatusOK, http.StatusNotFound}),
+				ClientWithNegativeTTL(5 * time.Second),
+			},
+			status:     http.StatusNotFound,
+			wantStored: true,
+			wantTTL:    5 * time.Second,
+		},
+		{
+			name: "negative ttl does not apply to other codes",
+			opts: []ClientOption{
+				ClientWithStatusCodes([]int{http.StatusOK, http.StatusNotFound}),
+				ClientWithNegativeTTL(5 * time.Second),
+			},
+			status:     http.StatusOK,
+			wantStored: true,
+			wantTTL:    time.Minute,
+		},
+		{
+			name: "status func",
+			opts: []ClientOption{
+				ClientWithStatusFunc(func(statusCode int) bool {
+					return statusCode == http.StatusGone
+				}),
+			},
+			status:     http.StatusGone,
+			wantStored: true,
+			wantTTL:    time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			adapter := &adapterMock{
+				store: map[uint64][]byte{},
+			}
+
+			opts := append([]ClientOption{
+				ClientWithAdapter(adapter),
+				ClientWithTTL(1 * time.Minute),
+			}, tt.opts...)
+			client, err := NewClient(opts...)
+			require.NoError(t, err)
+
+			handler := func(c echo.Context) error {
+				return c.NoContent(tt.status)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+			rec := httptest.NewRecorder()
+			before := time.Now()
+			require.NoError(t, client.Middleware()(handler)(e.NewContext(req, rec)))
+
+			if !tt.wantStored {
+				assert.Len(t, adapter.store, 0)
+				return
+			}
+			require.Len(t, adapter.store, 1)
+			for _, b := range adapter.store {
+				response := BytesToResponse(b)
+				assert.Equal(t, tt.status, response.StatusCode)
+				assert.WithinDuration(t, before.Add(tt.wantTTL), response.Expiration, time.Second)
+			}
+		})
+	}
+}
+
+func TestStatusCodesReturnedErrors(t *testing.T) {
+	diskAdapter, err := disk.NewAdapter(disk.WithDirectory(filepath.Join(t.TempDir(), "cache")))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		opts  []ClientOption
+		stale bool
+	}{
+		{
+			name: "caches returned http errors",
+		},
+		{
+			name: "caches streamed http errors",
+			opts: []ClientOption{ClientWithAdapter(diskAdapter), ClientWithStreaming(true)},
+		},
+		{
+			name:  "caches http errors returned instead of stale responses",
+			opts:  []ClientOption{ClientWithStaleIfError(1 * time.Minute)},
+			stale: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter := &adapterMock{
+				store: map[uint64][]byte{},
+			}
+			opts := append([]ClientOption{
+				ClientWithAdapter(adapter),
+				ClientWithTTL(100 * time.Millisecond),
+				ClientWithStatusCodes([]int{http.StatusOK, http.StatusNotFound}),
+				ClientWithNegativeTTL(1 * time.Minute),
+			}, tt.opts...)
+			client, err := NewClient(opts...)
+			require.NoError(t, err)
+
+			calls := 0
+			found := tt.stale
+			e := echo.New()
+			e.GET("/coins/btc", func(c echo.Context) error {
+				calls++
+				if found {
+					found = false
+					return c.String(http.StatusOK, "btc")
+				}
+				return echo.ErrNotFound
+			}, client.Middleware())
+			serve := func() *httptest.ResponseRecorder {
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/coins/btc", nil))
+				return rec
+			}
+
+			if tt.stale {
+				serve()
+				time.Sleep(150 * time.Millisecond)
+				calls = 0
+			}
+			for i := 0; i < 3; i++ {
+				rec := serve()
+				assert.Equal(t, http.StatusNotFound, rec.Code)
+				assert.Equal(t, "{\"message\":\"Not Found\"}\n", rec.Body.String())
+			}
+			assert.Equal(t, 1, calls)
+		})
+	}
+}
+
+func TestCacheStatus(t *testing.T) {
+	e := echo.New()
+	adapter := &adapterMock{
+		store: map[uint64][]byte{},
+	}
+
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithRefreshKey("rk"),
+		ClientWithStaleIfError(1*time.Minute),
+		ClientWithCacheStatus("echo"),
+		ClientWithMaxBodySize(16),
+	)
+	require.NoError(t, err)
+
+	// shift moves the cached responses back in time
+	shift := func(d time.Duration) {
+		adapter.Lock()
+		defer adapter.Unlock()
+		for k, b := range adapter.store {
+			response := BytesToResponse(b)
+			response.Created = response.Created.Add(-d)
+			response.Expiration = response.Expiration.Add(-d)
+			adapter.store[k] = response.Bytes()
+		}
+	}
+	ok := func(c echo.Context) error {
+		c.Response().Header().Add(HeaderCacheStatus, "upstream; hit")
+		return c.String(http.StatusOK, "value")
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		shift      time.Duration
+		handler    echo.HandlerFunc
+		wantStatus []string
+		wantAge    string
+	}{
+		{
+			name:       "miss",
+			handler:    ok,
+			wantStatus: []string{"upstream; hit", "echo; fwd=miss; fwd-status=200"},
+			wantAge:    "0",
+		},
+		{
+			name:       "hit",
+			shift:      10 * time.Second,
+			handler:    ok,
+			wantStatus: []string{"upstream; hit", "echo; hit; ttl=49"},
+			wantAge:    "10",
+		},
+		{
+			name:       "refresh",
+			target:     "/test?rk=1",
+			handler:    ok,
+			wantStatus: []string{"upstream; hit", "echo; fwd=request; fwd-status=200"},
+			wantAge:    "0",
+		},
+		{
+			name:  "stale on error",
+			shift: 90 * time.Second,
+			handler: func(c echo.Context) error {
+				return c.String(http.StatusBadGateway, "bad gateway")
+			},
+			wantStatus: []string{"upstream; hit", "echo; fwd=stale; fwd-status=502; ttl=-30"},
+			wantAge:    "90",
+		},
+		{
+			name:   "bypass",
+			method: http.MethodPost,
+			handler: func(c echo.Context) error {
+				return c.String(http.StatusCreated, "created")
+			},
+			wantStatus: []string{"echo; fwd=bypass; fwd-status=201"},
+		},
+		{
+			name:   "too large",
+			target: "/large",
+			handler: func(c echo.Context) error {
+				return c.String(http.StatusOK, strings.Repeat("large", 10))
+			},
+			wantStatus: []string{"echo; fwd=miss; fwd-status=200"},
+			wantAge:    "0",
+		},
+		{
+			name:   "too large not stored",
+			target: "/large",
+			handler: func(c echo.Context) error {
+				return c.String(http.StatusOK, strings.Repeat("large", 10))
+			},
+			wantStatus: []string{"echo; fwd=miss; fwd-status=200"},
+			wantAge:    "0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shift(tt.shift)
+			method, target := tt.method, tt.target
+			if method == "" {
+				method = http.MethodGet
+			}
+			if target == "" {
+				target = "/test"
+			}
+
+			req := httptest.NewRequest(method, "http://foo.bar"+target, nil)
+			rec := httptest.NewRecorder()
+			require.NoError(t, client.Middleware()(tt.handler)(e.NewContext(req, rec)))
+
+			assert.Equal(t, tt.wantStatus, rec.Header().Values(HeaderCacheStatus))
+			assert.Equal(t, tt.wantAge, rec.Header().Get("Age"))
+		})
+	}
+
+	adapter.Lock()
+	defer adapter.Unlock()
+	for _, b := range adapter.store {
+		response := BytesToResponse(b)
+		assert.Equal(t, []string{"upstream; hit"}, response.Header.Values(HeaderCacheStatus))
+		assert.Empty(t, response.Header.Get("Age"))
+	}
+}
+
+type failingAdapter struct {
+	AdapterV2
+}
+
+func (failingAdapter) Get(_ context.Context, _ uint64) ([]byte, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (failingAdapter) Set(_ context.Context, _ uint64, _ []byte, _ time.Time) error {
+	return nil
+}
+
+func TestClientWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	client, err := NewClient(
+		ClientWithAdapterV2(failingAdapter{}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithLogger(slog.New(slog.NewJSONHandler(&buf, nil))),
+	)
+	require.NoError(t, err)
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "http://foo.bar/test", nil)
+	rec := httptest.NewRecorder()
+	require.NoError(t, client.Middleware()(func(c echo.Context) error {
+		return c.String(http.StatusOK, "ok")
+	})(e.NewContext(req, rec)))
+	assert.Equal(t, "ok", rec.Body.String())
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(bytes.SplitN(buf.Bytes(), []byte("\n"), 2)[0], &entry))
+	assert.Equal(t, "ERROR", entry["level"])
+	assert.Equal(t, "cache get failed", entry["msg"])
+	assert.Equal(t, "connection refused", entry["error"])
+	assert.NotEmpty(t, entry["key"])
+}
+
+func TestMaxBodySize(t *testing.T) {
+	e := echo.New()
+	adapter := &adapterMock{
+		store: map[uint64][]byte{},
+	}
+
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithMaxBodySize(10),
+	)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		url        string
+		handler    echo.HandlerFunc
+		wantBody   string
+		wantStored bool
+	}{
+		{
+			name: "stores small response",
+			url:  "http://foo.bar/small",
+			handler: func(c echo.Context) error {
+				return c.String(http.StatusOK, "small")
+			},
+			wantBody:   "small",
+			wantStored: true,
+		},
+		{
+			name: "serves large response without storing it",
+			url:  "http://foo.bar/large",
+			handler: func(c echo.Context) error {
+				return c.String(http.StatusOK, "large response")
+			},
+			wantBody: "large response",
+		},
+		{
+			name: "serves event stream without storing it",
+			url:  "http://foo.bar/events",
+			handler: func(c echo.Context) error {
+				c.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
+				return c.String(http.StatusOK, "data: 1\n\n")
+			},
+			wantBody: "data: 1\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			rec := httptest.NewRecorder()
+			require.NoError(t, client.Middleware()(tt.handler)(e.NewContext(req, rec)))
+
+			assert.Equal(t, tt.wantBody, rec.Body.String())
+			_, ok := adapter.Get(generateKey(tt.url))
+			assert.Equal(t, tt.wantStored, ok)
+		})
+	}
+
+	t.Run("passes large response through when stale if error", func(t *testing.T) {
+		client, err := NewClient(
+			ClientWithAdapter(adapter),
+			ClientWithTTL(1*time.Millisecond),
+			ClientWithStaleIfError(1*time.Minute),
+			ClientWithMaxBodySize(10),
+		)
+		require.NoError(t, err)
+
+		serve := func(body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "http://foo.bar/stale", nil)
+			rec := httptest.NewRecorder()
+			require.NoError(t, client.Middleware()(func(c echo.Context) error {
+				return c.String(http.StatusOK, body)
+			})(e.NewContext(req, rec)))
+			return rec
+		}
+		serve("small")
+		time.Sleep(5 * time.Millisecond)
+
+		rec := serve("large response")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "large response", rec.Body.String())
+		assert.Empty(t, rec.Header().Get("Warning"))
+	})
+}
+
+func TestStreaming(t *testing.T) {
+	diskAdapter, err := disk.NewAdapter(disk.WithDirectory(filepath.Join(t.TempDir(), "cache")))
+	require.NoError(t, err)
+
+	client, err := NewClient(
+		ClientWithAdapter(diskAdapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithStreaming(true),
+		ClientWithConditionalRequests(true),
+	)
+	require.NoError(t, err)
+
+	calls := 0
+	e := echo.New()
+	e.Use(client.Middleware())
+	e.GET("/test", func(c echo.Context) error {
+		calls++
+		c.Response().WriteHeader(http.StatusOK)
+		for i := 0; i < 3; i++ {
+			if _, err := fmt.Fprintf(c.Response(), "chunk %d\n", i); err != nil {
+				return err
+			}
+			c.Response().Flush()
+		}
+		return nil
+	})
+
+	var etag string
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "chunk 0\nchunk 1\nchunk 2\n", rec.Body.String())
+		if i == 1 {
+			etag = rec.Header().Get("ETag")
+		}
+	}
+	assert.Equal(t, 1, calls)
+	// the response and its body stream
+	n, err := diskAdapter.Len()
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, fmt.Sprintf(`"%x"`, hashBody([]byte("chunk 0\nchunk 1\nchunk 2\n"))), etag)
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+
+	t.Run("requires a stream adapter", func(t *testing.T) {
+		_, err := NewClient(
+			ClientWithAdapter(&adapterMock{}),
+			ClientWithTTL(1*time.Minute),
+			ClientWithStreaming(true),
+		)
+		assert.EqualError(t, err, "cache client adapter does not support streaming")
+	})
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// responseTTL derives how long a response may be stored from the
+// Cache-Control and Expires headers set by the handler. It returns false
+// when the response must not be stored at all. If the handler says nothing
+// about caching, the fallback ttl is returned.
+func responseTTL(header http.Header, now time.Time, fallback time.Duration) (time.Duration, bool) {
+	directives := parseCacheControl(header.Values("Cache-Control"))
+
+	// no-cache allows storing but requires revalidation on every use,
+	// which the middleware cannot do, so it is treated like no-store.
+	for _, d := range []string{"no-store", "private", "no-cache"} {
+		if _, ok := directives[d]; ok {
+			return 0, false
+		}
+	}
+
+	for _, d := range []string{"s-maxage", "max-age"} {
+		if v, ok := directives[d]; ok {
+			seconds, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || seconds <= 0 {
+				return 0, false
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+
+	if v := header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			// invalid dates, like "0", represent a time in the past
+			return 0, false
+		}
+		if date, err := http.ParseTime(header.Get("Date")); err == nil {
+			now = date
+		}
+		ttl := expires.Sub(now)
+		if ttl <= 0 {
+			return 0, false
+		}
+		return ttl, true
+	}
+
+	return fallback, true
+}
+
+// parseCacheControl parses Cache-Control header values into a map of
+// lower-cased directive names and their unquoted arguments.
+func parseCacheControl(values []string) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, arg, _ := strings.Cut(part, "=")
+			directives[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(arg), `"`)
+		}
+	}
+	return directives
+}
@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderCacheStatus is the RFC 9211 response header reporting how caches
+// handled a request.
+const HeaderCacheStatus = "Cache-Status"
+
+const cacheStatusContextKey = "echo-http-cache.cache-status"
+
+// Forward reasons of the Cache-Status header fwd parameter.
+const (
+	fwdBypass  = "bypass"
+	fwdMiss    = "miss"
+	fwdRequest = "request"
+	fwdStale   = "stale"
+)
+
+// cacheStatus describes how the middleware handled a request.
+type cacheStatus struct {
+	// response is the cached response served, nil when the handler
+	// response is served.
+	response *Response
+
+	// fwd is the reason the request is forwarded to the handler.
+	fwd string
+
+	// fwdStatus is the status code of the failed handler response when
+	// the expired response is served instead.
+	fwdStatus int
+
+	// policy is the policy the forwarded response may be stored with, nil
+	// when the response is not cacheable.
+	policy *Policy
+}
+
+// ClientWithCacheStatus turns on the RFC 9211 Cache-Status response header,
+// reporting whether responses are served from the cache, with name as the
+// cache identifier. The Age header is set as well. Forwarded responses are
+// not reported as stored, the header being sent before storing is done. An
+// empty name turns it off.
+func ClientWithCacheStatus(name string) ClientOption {
+	return func(c *Client) error {
+		c.cacheStatus = name
+		return nil
+	}
+}
+
+// setCacheStatus sets how the middleware handled a request. The Cache-Status
+// and Age headers are added once the response status code is known, after
+// the cached response headers are copied.
+func (client *Client) setCacheStatus(c echo.Context, status cacheStatus) {
+	if client.cacheStatus == "" {
+		return
+	}
+
+	_, registered := c.Get(cacheStatusContextKey).(*cacheStatus)
+	c.Set(cacheStatusContextKey, &status)
+	if registered {
+		return
+	}
+
+	c.Response().Before(func() {
+		s, _ := c.Get(cacheStatusContextKey).(*cacheStatus)
+		if s == nil {
+			return
+		}
+
+		now := time.Now()
+		header := c.Response().Header()
+		params := []string{client.cacheStatus}
+		switch {
+		case s.fwd == "":
+			params = append(params, "hit", "ttl="+ttlSeconds(s.response.Expiration, now))
+			header.Set("Age", s.response.age(now))
+		case s.response != nil:
+			// the handler failed, the expired response is served
+			params = append(params, "fwd="+s.fwd)
+			if s.fwdStatus != 0 {
+				params = append(params, "fwd-status="+strconv.Itoa(s.fwdStatus))
+			}
+			params = append(params, "ttl="+ttlSeconds(s.response.Expiration, now))
+			header.Set("Age", s.response.age(now))
+		default:
+			// the header is sent before the body, which may still be too
+			// large to store or fail to be stored, so stored is not reported
+			params = append(params, "fwd="+s.fwd, "fwd-status="+strconv.Itoa(c.Response().Status))
+			if s.policy != nil && header.Get("Age") == "" {
+				header.Set("Age", "0")
+			}
+		}
+		header.Add(HeaderCacheStatus, strings.Join(params, "; "))
+	})
+}
+
+// stripCacheStatus removes the headers set by setCacheStatus from a response
+// header about to be cached.
+func (client *Client) stripCacheStatus(header http.Header) {
+	if client.cacheStatus == "" {
+		return
+	}
+
+	header.Del("Age")
+	values := header.Values(HeaderCacheStatus)
+	header.Del(HeaderCacheStatus)
+	for _, v := range values {
+		var members []string
+		for _, member := range strings.Split(v, ",") {
+			member = strings.TrimSpace(member)
+			name, _, _ := strings.Cut(member, ";")
+			if member != "" && strings.TrimSpace(name) != client.cacheStatus {
+				members = append(members, member)
+			}
+		}
+		if len(members) > 0 {
+			header.Add(HeaderCacheStatus, strings.Join(members, ", "))
+		}
+	}
+}
+
+// ttlSeconds returns the remaining freshness lifetime of a response in
+// seconds, negative when the response is stale.
+func ttlSeconds(expiration, now time.Time) string {
+	return strconv.FormatInt(int64(expiration.Sub(now)/time.Second), 10)
+}
@@ -0,0 +1,239 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/labstack/echo/v4"
+)
+
+// compressMinSize is the size of the smallest response body compressed,
+// smaller bodies hardly shrink.
+const compressMinSize = 256
+
+// Codec compresses the bodies of the cached responses.
+type Codec interface {
+	// Encoding returns the HTTP content coding of the encoded bodies, such
+	// as gzip. Encoded bodies are served as they are to the clients
+	// accepting it.
+	Encoding() string
+
+	// Encode compresses a response body.
+	Encode(b []byte) ([]byte, error)
+
+	// Decode decompresses a response body.
+	Decode(b []byte) ([]byte, error)
+}
+
+// codecs are the codecs bodies are decoded with, by encoding, when the
+// client codec is another one.
+var codecs = map[string]Codec{
+	"gzip":   GzipCodec{},
+	"zstd":   ZstdCodec{},
+	"snappy": SnappyCodec{},
+}
+
+// ClientWithCodec sets the codec compressing the cached response bodies.
+// Bodies already encoded by the handler, streamed bodies and bodies smaller
+// than 256 bytes are stored as they are.
+func ClientWithCodec(codec Codec) ClientOption {
+	return func(c *Client) error {
+		c.codec = codec
+		return nil
+	}
+}
+
+// GzipCodec compresses bodies with gzip at a given compression level, the
+// default level when zero.
+type GzipCodec struct {
+	Level int
+}
+
+func (GzipCodec) Encoding() string {
+	return "gzip"
+}
+
+func (c GzipCodec) Encode(b []byte) ([]byte, error) {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GzipCodec) Decode(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// ZstdCodec compresses bodies with Zstandard.
+type ZstdCodec struct{}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// zstdCoders initializes the encoder and decoder shared by all ZstdCodec,
+// both are safe for concurrent use.
+func zstdCoders() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		if zstdEncoder, zstdErr = zstd.NewWriter(nil); zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+	return zstdEncoder, zstdDecoder, zstdErr
+}
+
+func (ZstdCodec) Encoding() string {
+	return "zstd"
+}
+
+func (ZstdCodec) Encode(b []byte) ([]byte, error) {
+	enc, _, err := zstdCoders()
+	if err != nil {
+		return nil, err
+	}
+	return enc.EncodeAll(b, nil), nil
+}
+
+func (ZstdCodec) Decode(b []byte) ([]byte, error) {
+	_, dec, err := zstdCoders()
+	if err != nil {
+		return nil, err
+	}
+	return dec.DecodeAll(b, nil)
+}
+
+// SnappyCodec compresses bodies with the Snappy block format, trading
+// compression ratio for speed.
+type SnappyCodec struct{}
+
+func (SnappyCodec) Encoding() string {
+	return "snappy"
+}
+
+func (SnappyCodec) Encode(b []byte) ([]byte, error) {
+	return snappy.Encode(nil, b), nil
+}
+
+func (SnappyCodec) Decode(b []byte) ([]byte, error) {
+	return snappy.Decode(nil, b)
+}
+
+// compress compresses the body of a response about to be cached with the
+// client codec, unless it is not worth it.
+func (client *Client) compress(response *Response) {
+	if client.codec == nil || len(response.Value) < compressMinSize || response.Header.Get(echo.HeaderContentEncoding) != "" {
+		return
+	}
+
+	b, err := client.codec.Encode(response.Value)
+	if err != nil {
+		client.log().Error("cache response compression failed", "encoding", client.codec.Encoding(), "error", err)
+		return
+	}
+	if len(b) < len(response.Value) {
+		response.Value = b
+		response.Encoding = client.codec.Encoding()
+	}
+}
+
+// decompress returns the decompressed body of a cached response.
+func (client *Client) decompress(response Response) ([]byte, error) {
+	codec, ok := codecs[response.Encoding]
+	if client.codec != nil && client.codec.Encoding() == response.Encoding {
+		codec, ok = client.codec, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("cache response encoding %q is not supported", response.Encoding)
+	}
+	return codec.Decode(response.Value)
+}
+
+// encodedHeader returns the headers of a cached response with an encoded
+// body, and the body to serve, decompressing it unless the request accepts
+// its encoding.
+func (client *Client) encodedHeader(r *http.Request, response Response) (http.Header, []byte, error) {
+	header := response.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if !hasToken(header.Values(echo.HeaderVary), echo.HeaderAcceptEncoding) {
+		header.Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+	}
+
+	if !acceptsEncoding(r.Header.Values(echo.HeaderAcceptEncoding), response.Encoding) {
+		value, err := client.decompress(response)
+		return header, value, err
+	}
+
+	header.Set(echo.HeaderContentEncoding, response.Encoding)
+	header.Set(echo.HeaderContentLength, strconv.Itoa(len(response.Value)))
+	if etag := header.Get(headerETag); etag != "" {
+		// the encoded body is another representation
+		if strings.HasSuffix(etag, `"`) {
+			header.Set(headerETag, strings.TrimSuffix(etag, `"`)+"-"+response.Encoding+`"`)
+		}
+	}
+	return header, response.Value, nil
+}
+
+// acceptsEncoding reports whether an Accept-Encoding request header lists a
+// content coding, with a non-zero quality.
+func acceptsEncoding(accept []string, encoding string) bool {
+	for _, v := range accept {
+		for _, part := range strings.Split(v, ",") {
+			coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			if !strings.EqualFold(strings.TrimSpace(coding), encoding) {
+				continue
+			}
+			q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+			if !ok {
+				return true
+			}
+			quality, err := strconv.ParseFloat(q, 64)
+			return err == nil && quality > 0
+		}
+	}
+	return false
+}
+
+// hasToken reports whether a comma separated list header holds a token.
+func hasToken(values []string, token string) bool {
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
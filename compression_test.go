@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodecs(t *testing.T) {
+	body := []byte(strings.Repeat(`{"id":"btc-bitcoin","name":"Bitcoin"}`, 20))
+
+	for _, codec := range []Codec{GzipCodec{}, ZstdCodec{}, SnappyCodec{}} {
+		t.Run(codec.Encoding(), func(t *testing.T) {
+			encoded, err := codec.Encode(body)
+			require.NoError(t, err)
+			assert.Less(t, len(encoded), len(body))
+
+			decoded, err := codec.Decode(encoded)
+			require.NoError(t, err)
+			assert.Equal(t, body, decoded)
+
+			_, err = codec.Decode([]byte("not encoded"))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestCompression(t *testing.T) {
+	e := echo.New()
+	adapter := &adapterMock{
+		store: map[uint64][]byte{},
+	}
+
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithCodec(GzipCodec{}),
+		ClientWithConditionalRequests(true),
+	)
+	require.NoError(t, err)
+
+	large := strings.Repeat("value ", 100)
+	handler := func(c echo.Context) error {
+		switch c.Request().URL.Path {
+		case "/small":
+			return c.String(http.StatusOK, "small")
+		case "/encoded":
+			c.Response().Header().Set(echo.HeaderContentEncoding, "br")
+		}
+		return c.String(http.StatusOK, large)
+	}
+	serve := func(url string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		require.NoError(t, client.Middleware()(handler)(e.NewContext(req, rec)))
+		return rec
+	}
+
+	tests := []struct {
+		name         string
+		url          string
+		wantEncoding string
+	}{
+		{"compresses large response", "http://foo.bar/large", "gzip"},
+		{"stores small response as is", "http://foo.bar/small", ""},
+		{"stores encoded response as is", "http://foo.bar/encoded", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serve(tt.url, nil)
+
+			b, ok := adapter.Get(generateKey(tt.url))
+			require.True(t, ok)
+			assert.Equal(t, tt.wantEncoding, BytesToResponse(b).Encoding)
+		})
+	}
+
+	t.Run("serves compressed response to accepting clients", func(t *testing.T) {
+		rec := serve("http://foo.bar/large", http.Header{"Accept-Encoding": {"br, gzip;q=0.8"}})
+		assert.Equal(t, "gzip", rec.Header().Get(echo.HeaderContentEncoding))
+		assert.Equal(t, echo.HeaderAcceptEncoding, rec.Header().Get(echo.HeaderVary))
+
+		body, err := GzipCodec{}.Decode(rec.Body.Bytes())
+		require.NoError(t, err)
+		assert.Equal(t, large, string(body))
+
+		etag := rec.Header().Get("ETag")
+		assert.True(t, strings.HasSuffix(etag, `-gzip"`))
+		rec = serve("http://foo.bar/large", http.Header{"Accept-Encoding": {"gzip"}, "If-None-Match": {etag}})
+		assert.Equal(t, http.StatusNotModified, rec.Code)
+	})
+
+	t.Run("decompresses response for other clients", func(t *testing.T) {
+		for _, accept := range []string{"", "br", "gzip;q=0"} {
+			rec := serve("http://foo.bar/large", http.Header{"Accept-Encoding": {accept}})
+			assert.Empty(t, rec.Header().Get(echo.HeaderContentEncoding))
+			assert.Equal(t, echo.HeaderAcceptEncoding, rec.Header().Get(echo.HeaderVary))
+			assert.Equal(t, large, rec.Body.String())
+		}
+	})
+
+	t.Run("decodes responses compressed with another codec", func(t *testing.T) {
+		client, err := NewClient(
+			ClientWithAdapter(adapter),
+			ClientWithTTL(1*time.Minute),
+			ClientWithCodec(SnappyCodec{}),
+		)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "http://foo.bar/large", nil)
+		rec := httptest.NewRecorder()
+		require.NoError(t, client.Middleware()(handler)(e.NewContext(req, rec)))
+		assert.Equal(t, large, rec.Body.String())
+	})
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		accept []string
+		want   bool
+	}{
+		{nil, false},
+		{[]string{"gzip"}, true},
+		{[]string{"deflate, GZIP"}, true},
+		{[]string{"br", "gzip;q=0.5"}, true},
+		{[]string{"gzip; q=0"}, false},
+		{[]string{"x-gzip"}, false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, acceptsEncoding(tt.accept, "gzip"), tt.accept)
+	}
+}
@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	headerETag        = "ETag"
+	headerIfNoneMatch = "If-None-Match"
+)
+
+// notModifiedHeaders are the stored headers sent along with a 304 response.
+var notModifiedHeaders = []string{
+	echo.HeaderCacheControl,
+	"Content-Location",
+	"Date",
+	headerETag,
+	"Expires",
+	echo.HeaderLastModified,
+	echo.HeaderVary,
+}
+
+// setValidators adds an ETag computed from the body hash and a Last-Modified
+// date to a response about to be cached, unless the handler has already
+// set them.
+func setValidators(header http.Header, bodyHash uint64, now time.Time) {
+	if header.Get(headerETag) == "" {
+		header.Set(headerETag, `"`+strconv.FormatUint(bodyHash, 16)+`"`)
+	}
+	if header.Get(echo.HeaderLastModified) == "" {
+		header.Set(echo.HeaderLastModified, now.UTC().Format(http.TimeFormat))
+	}
+}
+
+// setLastModified adds a Last-Modified date to the handler response of a
+// cache miss, unless the handler has already set it, once it turns out to be
+// cacheable. Clients can make conditional requests from the first response
+// on, while the ETag, computed from the complete body, is only sent from the
+// first hit on.
+func (client *Client) setLastModified(c echo.Context, p Policy) {
+	if !client.conditional {
+		return
+	}
+
+	written := false
+	c.Response().Before(func() {
+		// a stale response served instead has its own validators
+		if written {
+			return
+		}
+		written = true
+
+		now := time.Now()
+		header := c.Response().Header()
+		if header.Get(echo.HeaderLastModified) != "" || isEventStream(header) {
+			return
+		}
+		if _, ok := client.storeTTL(p, c.Response().Status, header, now); ok {
+			header.Set(echo.HeaderLastModified, now.UTC().Format(http.TimeFormat))
+		}
+	})
+}
+
+// notModified evaluates the If-None-Match and If-Modified-Since request
+// preconditions against the validators of a cached response.
+func notModified(r *http.Request, header http.Header) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if inm := r.Header.Get(headerIfNoneMatch); inm != "" {
+		etag := header.Get(headerETag)
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || weakETag(candidate) == weakETag(etag) {
+				return true
+			}
+		}
+		return false
+	}
+
+	ims, err := http.ParseTime(r.Header.Get(echo.HeaderIfModifiedSince))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(header.Get(echo.HeaderLastModified))
+	if err != nil {
+		return false
+	}
+	return !lastModified.After(ims)
+}
+
+// weakETag strips the weak indicator, If-None-Match uses weak comparison.
+func weakETag(etag string) string {
+	return strings.TrimPrefix(etag, "W/")
+}
+
+// hashBody returns the hash of a response body the ETag is computed from.
+func hashBody(body []byte) uint64 {
+	hash := fnv.New64a()
+	hash.Write(body)
+	return hash.Sum64()
+}
@@ -0,0 +1,263 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Responses are encoded as the responseMagic, a version byte, and a list of
+// fields. Each field is encoded as its tag, the length of its value and the
+// value, all lengths and integers as varints. Zero fields are left out and
+// unknown fields are skipped, so fields can be added without a new version.
+//
+// A gob stream never starts with the magic, entries encoded with gob by
+// previous versions are still decoded.
+const (
+	responseMagic   = "EHCR"
+	responseVersion = 1
+)
+
+const (
+	fieldValue = iota + 1
+	fieldHeader
+	fieldExpiration
+	fieldLastAccess
+	fieldFrequency
+	fieldStatusCode
+	fieldCreated
+	fieldVary
+	fieldStreamKey
+	fieldEncoding
+	fieldPath
+)
+
+// ErrInvalidResponse is returned when decoding bytes which are not a valid
+// encoded Response.
+var ErrInvalidResponse = errors.New("cache: invalid response encoding")
+
+// Bytes converts Response data structure into bytes array.
+func (r Response) Bytes() []byte {
+	b := make([]byte, 0, len(responseMagic)+1+len(r.Value)+64)
+	b = append(b, responseMagic...)
+	b = append(b, responseVersion)
+
+	if len(r.Value) > 0 {
+		b = appendField(b, fieldValue, r.Value)
+	}
+	if len(r.Header) > 0 {
+		var h []byte
+		h = binary.AppendUvarint(h, uint64(len(r.Header)))
+		for k, values := range r.Header {
+			h = appendString(h, k)
+			h = appendStrings(h, values)
+		}
+		b = appendField(b, fieldHeader, h)
+	}
+	b = appendTime(b, fieldExpiration, r.Expiration)
+	b = appendTime(b, fieldLastAccess, r.LastAccess)
+	if r.Frequency != 0 {
+		b = appendField(b, fieldFrequency, binary.AppendVarint(nil, int64(r.Frequency)))
+	}
+	if r.StatusCode != 0 {
+		b = appendField(b, fieldStatusCode, binary.AppendVarint(nil, int64(r.StatusCode)))
+	}
+	b = appendTime(b, fieldCreated, r.Created)
+	if len(r.Vary) > 0 {
+		b = appendField(b, fieldVary, appendStrings(nil, r.Vary))
+	}
+	if r.StreamKey != 0 {
+		b = appendField(b, fieldStreamKey, binary.AppendUvarint(nil, r.StreamKey))
+	}
+	if r.Encoding != "" {
+		b = appendField(b, fieldEncoding, []byte(r.Encoding))
+	}
+	if r.Path != "" {
+		b = appendField(b, fieldPath, []byte(r.Path))
+	}
+	return b
+}
+
+// BytesToResponse converts bytes array into Response data structure.
+// Decoding errors are logged and a zero Response is returned, use
+// DecodeResponse to handle them.
+func BytesToResponse(b []byte) Response {
+	r, err := DecodeResponse(b)
+	if err != nil {
+		slog.Error("cache response decoding failed", "error", err)
+	}
+	return r
+}
+
+// DecodeResponse decodes a Response encoded with Response.Bytes, or with
+// gob by previous versions. The Value of the decoded Response shares memory
+// with b.
+func DecodeResponse(b []byte) (Response, error) {
+	if !bytes.HasPrefix(b, []byte(responseMagic)) {
+		return decodeGobResponse(b)
+	}
+	b = b[len(responseMagic):]
+	if len(b) == 0 {
+		return Response{}, fmt.Errorf("%w: missing version", ErrInvalidResponse)
+	}
+	if b[0] != responseVersion {
+		return Response{}, fmt.Errorf("%w: unsupported version %d", ErrInvalidResponse, b[0])
+	}
+
+	var r Response
+	d := decoder{b: b[1:]}
+	for len(d.b) > 0 {
+		tag := d.uvarint()
+		field := decoder{b: d.bytes()}
+		if d.err != nil {
+			return Response{}, fmt.Errorf("%w: %w", ErrInvalidResponse, d.err)
+		}
+
+		switch tag {
+		case fieldValue:
+			r.Value = field.b
+		case fieldHeader:
+			n := field.uvarint()
+			r.Header = make(http.Header, min(n, uint64(len(field.b))))
+			for i := uint64(0); i < n && field.err == nil; i++ {
+				k := field.string()
+				r.Header[k] = field.strings()
+			}
+		case fieldExpiration:
+			r.Expiration = field.time()
+		case fieldLastAccess:
+			r.LastAccess = field.time()
+		case fieldFrequency:
+			r.Frequency = int(field.varint())
+		case fieldStatusCode:
+			r.StatusCode = int(field.varint())
+		case fieldCreated:
+			r.Created = field.time()
+		case fieldVary:
+			r.Vary = field.strings()
+		case fieldStreamKey:
+			r.StreamKey = field.uvarint()
+		case fieldEncoding:
+			r.Encoding = string(field.b)
+		case fieldPath:
+			r.Path = string(field.b)
+		}
+		if field.err != nil {
+			return Response{}, fmt.Errorf("%w: field %d: %w", ErrInvalidResponse, tag, field.err)
+		}
+	}
+	return r, nil
+}
+
+// decodeGobResponse decodes a Response encoded with gob.
+func decodeGobResponse(b []byte) (Response, error) {
+	var r Response
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&r); err != nil {
+		return Response{}, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+	return r, nil
+}
+
+func appendField(b []byte, tag uint64, value []byte) []byte {
+	b = binary.AppendUvarint(b, tag)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+func appendTime(b []byte, tag uint64, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	return appendField(b, tag, binary.AppendVarint(nil, t.UnixNano()))
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendStrings(b []byte, s []string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	for _, v := range s {
+		b = appendString(b, v)
+	}
+	return b
+}
+
+// decoder reads the values of an encoded Response. It stops at the first
+// error, and reads zero values from then on.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+// bytes reads a length-prefixed value.
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if n > uint64(len(d.b)) {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	v := d.b[:n:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) strings() []string {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	s := make([]string, 0, min(n, uint64(len(d.b))))
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		s = append(s, d.string())
+	}
+	return s
+}
+
+func (d *decoder) time() time.Time {
+	v := d.varint()
+	if d.err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, v)
+}
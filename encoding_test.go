@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testResponse() Response {
+	now := time.Unix(1700000000, 123)
+	return Response{
+		Value: []byte(strings.Repeat(`{"id":"btc-bitcoin"}`, 10)),
+		Header: http.Header{
+			"Content-Type": {"application/json"},
+			"Set-Cookie":   {"a=1", "b=2"},
+		},
+		Expiration: now.Add(1 * time.Minute),
+		LastAccess: now,
+		Frequency:  3,
+		StatusCode: http.StatusOK,
+		Created:    now,
+		Vary:       []string{"Accept-Language"},
+		StreamKey:  42,
+		Encoding:   "gzip",
+		Path:       "/coins/btc",
+	}
+}
+
+func gobBytes(t testing.TB, r Response) []byte {
+	var b bytes.Buffer
+	require.NoError(t, gob.NewEncoder(&b).Encode(&r))
+	return b.Bytes()
+}
+
+func TestDecodeResponse(t *testing.T) {
+	r := testResponse()
+
+	tests := []struct {
+		name string
+		b    []byte
+		want Response
+	}{
+		{"decodes response", r.Bytes(), r},
+		{"decodes zero response", Response{}.Bytes(), Response{}},
+		{"decodes gob response", gobBytes(t, r), r},
+		{"skips unknown fields", appendField(Response{StatusCode: 204}.Bytes(), 99, []byte("future")), Response{StatusCode: 204}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeResponse(tt.b)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	invalid := []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"garbage", []byte("not a response")},
+		{"missing version", []byte(responseMagic)},
+		{"unsupported version", append([]byte(responseMagic), 2)},
+		{"truncated field", r.Bytes()[:20]},
+		{"truncated header", appendField([]byte(responseMagic+"\x01"), fieldHeader, []byte{2, 1, 'a'})},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeResponse(tt.b)
+			assert.ErrorIs(t, err, ErrInvalidResponse)
+		})
+	}
+}
+
+func TestResponseBytesSize(t *testing.T) {
+	r := testResponse()
+	assert.Less(t, len(r.Bytes()), len(gobBytes(t, r)))
+}
+
+func BenchmarkResponseBytes(b *testing.B) {
+	r := testResponse()
+
+	b.Run("binary", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = r.Bytes()
+		}
+	})
+	b.Run("gob", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = gobBytes(b, r)
+		}
+	})
+}
+
+func BenchmarkDecodeResponse(b *testing.B) {
+	r := testResponse()
+
+	for name, encoded := range map[string][]byte{
+		"binary": r.Bytes(),
+		"gob":    gobBytes(b, r),
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := DecodeResponse(encoded); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Invalidate frees the cached response of a request with the given method,
+// URL and Origin header, computing the same key the middleware does. All
+// variants of a response varying on request headers are freed. Request
+// headers set with ClientWithKeyHeaders are taken as absent and POST
+// requests as having an empty body. It fails once a policy sets KeyHeaders,
+// keys then depending on the route.
+func (client *Client) Invalidate(ctx context.Context, method, URL, origin string) error {
+	if !client.cacheableMethod(method) {
+		return nil
+	}
+
+	key, _, err := client.urlKey(method, URL, origin)
+	if err != nil {
+		return err
+	}
+	return client.release(ctx, key)
+}
+
+// listBatchSize is the number of entries read at once when all entries are
+// read to find the responses under a path.
+const listBatchSize = 100
+
+// InvalidatePrefix frees the cached responses of all requests whose URL
+// path is the given path or is below it, "/coins" covering "/coins/btc" but
+// not "/coinsbtc". It requires the path index to be enabled with
+// ClientWithPathIndex.
+func (client *Client) InvalidatePrefix(ctx context.Context, prefix string) error {
+	if !client.pathIndex {
+		return errors.New("cache client path index is not enabled")
+	}
+
+	prefix = path.Clean("/" + prefix)
+	if indexer, ok := adapterAs[Indexer](client.adapter); ok {
+		keys, err := indexer.TakeIndex(ctx, "path:"+prefix)
+		if !errors.Is(err, ErrNotSupported) {
+			return errors.Join(err, client.releaseKeys(ctx, keys))
+		}
+	}
+	return client.releaseUnderPath(ctx, prefix)
+}
+
+// pathIndexable reports whether an adapter supports the path index.
+func pathIndexable(a AdapterV2) bool {
+	if _, ok := adapterAs[Indexer](a); ok {
+		return true
+	}
+	_, ok := adapterAs[Lister](a)
+	return ok
+}
+
+// addToPathIndex adds a cache key to the sets of its path and parent paths,
+// when the adapter implements Indexer. Failures are logged.
+func (client *Client) addToPathIndex(ctx context.Context, p string, key uint64, expiration time.Time) {
+	indexer, ok := adapterAs[Indexer](client.adapter)
+	if !ok {
+		return
+	}
+	for _, prefix := range pathPrefixes(p) {
+		err := indexer.AddToIndex(ctx, "path:"+prefix, key, expiration)
+		if errors.Is(err, ErrNotSupported) {
+			return
+		}
+		if err != nil {
+			client.log().ErrorContext(ctx, "cache index update failed", "index", "path:"+prefix, "key", KeyAsString(key), "error", err)
+		}
+	}
+}
+
+// releaseUnderPath reads all entries to free the cached responses whose
+// path is below the given one.
+func (client *Client) releaseUnderPath(ctx context.Context, prefix string) error {
+	lister, ok := adapterAs[Lister](client.adapter)
+	if !ok {
+		return ErrNotSupported
+	}
+	keys, err := lister.Keys()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for batch := range slices.Chunk(keys, listBatchSize) {
+		responses, err := client.adapter.GetMulti(ctx, batch)
+		if err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+
+		var under []uint64
+		for key, b := range responses {
+			if response, err := DecodeResponse(b); err == nil && underPath(response.Path, prefix) {
+				under = append(under, key)
+			}
+		}
+		if err := client.releaseKeys(ctx, under); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// releaseKeys frees cache keys on every instance.
+func (client *Client) releaseKeys(ctx context.Context, keys []uint64) error {
+	var errs []error
+	for _, key := range keys {
+		if err := client.adapter.Delete(ctx, key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	client.publish(ctx, keys...)
+	return errors.Join(errs...)
+}
+
+// underPath reports whether a URL path is the given path or is below it.
+func underPath(p, prefix string) bool {
+	if p == "" {
+		return false
+	}
+	return prefix == "/" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// urlKey returns the key the middleware generates for a request with the
+// given method, URL and Origin header, along with the request.
+func (client *Client) urlKey(method, URL, origin string) (uint64, *http.Request, error) {
+	if client.keyFunc != nil {
+		return 0, nil, errors.New("cache client keys are generated by a custom key func")
+	}
+	if client.policyKeyHeaders.Load() {
+		return 0, nil, errors.New("cache client keys depend on the key headers of route policies")
+	}
+
+	u, err := url.Parse(URL)
+	if err != nil {
+		return 0, nil, err
+	}
+	sortURLParams(u)
+
+	r := &http.Request{
+		Method: method,
+		URL:    u,
+		Header: http.Header{},
+	}
+	if origin != "" {
+		r.Header.Set(echo.HeaderOrigin, origin)
+	}
+
+	return generateKey(keyURL(r, client.keyHeaders)), r, nil
+}
+
+// release frees the cached response stored under a request key, along with
+// all its variants.
+func (client *Client) release(ctx context.Context, key uint64) error {
+	err := errors.Join(
+		client.releaseIndex(ctx, varyIndexName(key)),
+		client.adapter.Delete(ctx, key),
+	)
+	client.publish(ctx, key)
+	return err
+}
+
+// varyIndexName returns the name of the index of the variants stored for a
+// request key.
+func varyIndexName(key uint64) string {
+	return "vary:" + strconv.FormatUint(key, 10)
+}
+
+// pathPrefixes returns the URL path and all its parent paths.
+func pathPrefixes(p string) []string {
+	p = path.Clean("/" + p)
+	prefixes := []string{"/"}
+	for i := 1; i < len(p); i++ {
+		if p[i] == '/' {
+			prefixes = append(prefixes, p[:i])
+		}
+	}
+	if p != "/" {
+		prefixes = append(prefixes, p)
+	}
+	return prefixes
+}
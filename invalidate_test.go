@@ -0,0 +1,195 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coinpaprika/echo-http-cache/adapter/memory"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvalidate(t *testing.T) {
+	adapter := &adapterMock{
+		store: map[uint64][]byte{},
+	}
+
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+	)
+	require.NoError(t, err)
+
+	calls := 0
+	e := echo.New()
+	e.Use(client.Middleware())
+	e.GET("/coins", func(c echo.Context) error {
+		calls++
+		c.Response().Header().Set(echo.HeaderVary, "Accept-Language")
+		return c.String(http.StatusOK, "ok")
+	})
+
+	serve := func(language string) {
+		req := httptest.NewRequest(http.MethodGet, "http://foo.bar/coins?b=2&a=1", nil)
+		req.Header.Set(echo.HeaderOrigin, "http://localhost")
+		req.Header.Set("Accept-Language", language)
+		e.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serve("en")
+	serve("de")
+	serve("en")
+	serve("de")
+	require.Equal(t, 2, calls)
+
+	require.NoError(t, client.Invalidate(context.Background(), http.MethodGet, "http://foo.bar/coins?a=1&b=2", "http://other"))
+	serve("en")
+	assert.Equal(t, 2, calls)
+
+	require.NoError(t, client.Invalidate(context.Background(), http.MethodGet, "http://foo.bar/coins?a=1&b=2", "http://localhost"))
+	serve("en")
+	serve("de")
+	assert.Equal(t, 4, calls)
+
+	keyFuncClient, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithKeyFunc(func(_ echo.Context) (uint64, bool) {
+			return 1, true
+		}),
+	)
+	require.NoError(t, err)
+	assert.Error(t, keyFuncClient.Invalidate(context.Background(), http.MethodGet, "http://foo.bar/coins", ""))
+
+	policyClient, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+	)
+	require.NoError(t, err)
+	require.NoError(t, policyClient.Invalidate(context.Background(), http.MethodGet, "http://foo.bar/coins", ""))
+	e.GET("/tickers", func(c echo.Context) error {
+		return c.String(http.StatusOK, "ok")
+	}, policyClient.MiddlewareWithPolicy(Policy{KeyHeaders: []string{"Accept-Language"}}))
+	assert.Error(t, policyClient.Invalidate(context.Background(), http.MethodGet, "http://foo.bar/tickers", ""))
+
+	routePolicyClient, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithPolicy("/tickers", Policy{KeyHeaders: []string{"Accept-Language"}}),
+	)
+	require.NoError(t, err)
+	assert.Error(t, routePolicyClient.Invalidate(context.Background(), http.MethodGet, "http://foo.bar/tickers", ""))
+}
+
+// listerMock is an adapter listing its keys, not implementing Indexer.
+type listerMock struct {
+	adapterMock
+}
+
+func (a *listerMock) Keys() ([]uint64, error) {
+	a.Lock()
+	defer a.Unlock()
+	keys := make([]uint64, 0, len(a.store))
+	for key := range a.store {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func TestInvalidatePrefix(t *testing.T) {
+	indexer, err := memory.NewAdapter()
+	require.NoError(t, err)
+
+	adapters := []struct {
+		name    string
+		adapter Adapter
+	}{
+		{"lister", &listerMock{adapterMock{store: map[uint64][]byte{}}}},
+		{"indexer", indexer},
+	}
+	for _, a := range adapters {
+		t.Run(a.name, func(t *testing.T) {
+			testInvalidatePrefix(t, a.adapter)
+		})
+	}
+
+	_, err = NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithPathIndex(true),
+	)
+	assert.Error(t, err)
+}
+
+func testInvalidatePrefix(t *testing.T, adapter Adapter) {
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithPathIndex(true),
+	)
+	require.NoError(t, err)
+
+	calls := map[string]int{}
+	e := echo.New()
+	e.Use(client.Middleware())
+	e.GET("/*", func(c echo.Context) error {
+		calls[c.Request().URL.Path]++
+		c.Response().Header().Set(echo.HeaderVary, "Accept-Language")
+		return c.String(http.StatusOK, "ok")
+	})
+
+	urls := []string{"/coins", "/coins/btc", "/coins/btc/ohlcv", "/coinsbtc", "/tickers/btc"}
+	serve := func() {
+		for _, url := range urls {
+			req := httptest.NewRequest(http.MethodGet, url, nil)
+			req.Header.Set("Accept-Language", "en")
+			e.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	}
+	serve()
+
+	require.NoError(t, client.InvalidatePrefix(context.Background(), "/coins/btc/"))
+	serve()
+	assert.Equal(t, map[string]int{
+		"/coins":           1,
+		"/coins/btc":       2,
+		"/coins/btc/ohlcv": 2,
+		"/coinsbtc":        1,
+		"/tickers/btc":     1,
+	}, calls)
+
+	require.NoError(t, client.InvalidatePrefix(context.Background(), "/"))
+	serve()
+	for _, url := range urls {
+		assert.Greater(t, calls[url], 1, url)
+	}
+
+	disabled, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+	)
+	require.NoError(t, err)
+	assert.Error(t, disabled.InvalidatePrefix(context.Background(), "/coins"))
+}
+
+func TestPathPrefixes(t *testing.T) {
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"/", []string{"/"}},
+		{"", []string{"/"}},
+		{"/coins", []string{"/", "/coins"}},
+		{"/coins/btc/", []string{"/", "/coins", "/coins/btc"}},
+		{"/coins//btc/ohlcv", []string{"/", "/coins", "/coins/btc", "/coins/btc/ohlcv"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.want, pathPrefixes(tt.path))
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Result is the outcome of a request handled by the middleware.
+type Result string
+
+const (
+	// ResultHit is a request answered with a fresh cached response.
+	ResultHit Result = "hit"
+
+	// ResultMiss is a request answered by the handler.
+	ResultMiss Result = "miss"
+
+	// ResultStale is a request answered with an expired cached response.
+	ResultStale Result = "stale"
+
+	// ResultBypass is a request the cache does not apply to.
+	ResultBypass Result = "bypass"
+)
+
+// Metrics receives instrumentation events of the middleware client. Routes
+// are the echo route paths, e.g. "/coins/:id".
+type Metrics interface {
+	// Request records the result of a request on a route.
+	Request(route string, result Result)
+
+	// Stored records the size of a response body stored for a route.
+	Stored(route string, size int)
+
+	// AdapterLatency records the duration of an adapter operation.
+	AdapterLatency(operation string, d time.Duration)
+
+	// Evicted records an entry evicted by an adapter. It matches the
+	// signature of the adapter WithOnEvict options.
+	Evicted(key uint64)
+}
+
+// ClientWithMetrics sets the metrics receiving the middleware client
+// instrumentation events.
+func ClientWithMetrics(m Metrics) ClientOption {
+	return func(c *Client) error {
+		c.metrics = m
+		return nil
+	}
+}
+
+// observe records the result of a request.
+func (client *Client) observe(c echo.Context, result Result) {
+	if client.metrics != nil {
+		client.metrics.Request(c.Path(), result)
+	}
+}
+
+// bypass records a request the cache does not apply to.
+func (client *Client) bypass(c echo.Context) {
+	client.observe(c, ResultBypass)
+	client.setCacheStatus(c, cacheStatus{fwd: fwdBypass})
+}
+
+// instrumentedAdapter records the latency of the operations of an adapter.
+type instrumentedAdapter struct {
+	adapter AdapterV2
+	metrics Metrics
+}
+
+// Unwrap returns the instrumented adapter.
+func (a *instrumentedAdapter) Unwrap() AdapterV2 {
+	return a.adapter
+}
+
+func (a *instrumentedAdapter) observe(operation string, start time.Time) {
+	a.metrics.AdapterLatency(operation, time.Since(start))
+}
+
+func (a *instrumentedAdapter) Get(ctx context.Context, key uint64) ([]byte, error) {
+	defer a.observe("get", time.Now())
+	return a.adapter.Get(ctx, key)
+}
+
+func (a *instrumentedAdapter) GetMulti(ctx context.Context, keys []uint64) (map[uint64][]byte, error) {
+	defer a.observe("get_multi", time.Now())
+	return a.adapter.GetMulti(ctx, keys)
+}
+
+func (a *instrumentedAdapter) Set(ctx context.Context, key uint64, response []byte, expiration time.Time) error {
+	defer a.observe("set", time.Now())
+	return a.adapter.Set(ctx, key, response, expiration)
+}
+
+func (a *instrumentedAdapter) SetMulti(ctx context.Context, entries []Entry) error {
+	defer a.observe("set_multi", time.Now())
+	return a.adapter.SetMulti(ctx, entries)
+}
+
+func (a *instrumentedAdapter) Delete(ctx context.Context, key uint64) error {
+	defer a.observe("delete", time.Now())
+	return a.adapter.Delete(ctx, key)
+}
+
+func (a *instrumentedAdapter) Clear(ctx context.Context) error {
+	defer a.observe("clear", time.Now())
+	return a.adapter.Clear(ctx)
+}
@@ -0,0 +1,216 @@
+// Package metrics implements the cache Metrics interface, exposing the
+// collected metrics in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cache "github.com/coinpaprika/echo-http-cache"
+)
+
+const namespace = "echo_http_cache"
+
+var (
+	// DefaultLatencyBuckets are the upper bounds, in seconds, of the adapter
+	// latency histogram buckets.
+	DefaultLatencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+	// DefaultSizeBuckets are the upper bounds, in bytes, of the stored
+	// response size histogram buckets.
+	DefaultSizeBuckets = []float64{256, 1024, 4096, 16384, 65536, 262144, 1048576}
+)
+
+type (
+	// Registry collects the middleware client metrics. It implements
+	// cache.Metrics and http.Handler, serving the metrics in the
+	// Prometheus text exposition format.
+	Registry struct {
+		mu             sync.Mutex
+		requests       map[requestLabels]uint64
+		latency        map[string]*histogram
+		stored         map[string]*histogram
+		latencyBuckets []float64
+		sizeBuckets    []float64
+		evictions      atomic.Uint64
+	}
+	RegistryOptions func(r *Registry)
+
+	requestLabels struct {
+		route  string
+		result cache.Result
+	}
+
+	histogram struct {
+		counts []uint64
+		sum    float64
+		count  uint64
+	}
+)
+
+// NewRegistry initializes a metrics registry.
+func NewRegistry(opts ...RegistryOptions) *Registry {
+	r := &Registry{
+		requests:       map[requestLabels]uint64{},
+		latency:        map[string]*histogram{},
+		stored:         map[string]*histogram{},
+		latencyBuckets: DefaultLatencyBuckets,
+		sizeBuckets:    DefaultSizeBuckets,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// WithLatencyBuckets sets the upper bounds, in seconds, of the adapter
+// latency histogram buckets.
+func WithLatencyBuckets(buckets []float64) RegistryOptions {
+	return func(r *Registry) {
+		r.latencyBuckets = sortedBuckets(buckets)
+	}
+}
+
+// WithSizeBuckets sets the upper bounds, in bytes, of the stored response
+// size histogram buckets.
+func WithSizeBuckets(buckets []float64) RegistryOptions {
+	return func(r *Registry) {
+		r.sizeBuckets = sortedBuckets(buckets)
+	}
+}
+
+// Request implements the cache Metrics interface Request method.
+func (r *Registry) Request(route string, result cache.Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests[requestLabels{route: route, result: result}]++
+}
+
+// Stored implements the cache Metrics interface Stored method.
+func (r *Registry) Stored(route string, size int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	observe(r.stored, route, r.sizeBuckets, float64(size))
+}
+
+// AdapterLatency implements the cache Metrics interface AdapterLatency
+// method.
+func (r *Registry) AdapterLatency(operation string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	observe(r.latency, operation, r.latencyBuckets, d.Seconds())
+}
+
+// Evicted implements the cache Metrics interface Evicted method.
+func (r *Registry) Evicted(_ uint64) {
+	r.evictions.Add(1)
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := r.WriteTo(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+
+	r.mu.Lock()
+	writeHeader(&b, "requests_total", "counter", "Requests handled by the cache middleware by route and result.")
+	labels := make([]requestLabels, 0, len(r.requests))
+	for l := range r.requests {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].route != labels[j].route {
+			return labels[i].route < labels[j].route
+		}
+		return labels[i].result < labels[j].result
+	})
+	for _, l := range labels {
+		fmt.Fprintf(&b, "%s_requests_total{route=%s,result=%s} %d\n", namespace, quote(l.route), quote(string(l.result)), r.requests[l])
+	}
+
+	writeHeader(&b, "adapter_duration_seconds", "histogram", "Duration of cache adapter operations.")
+	writeHistograms(&b, "adapter_duration_seconds", "operation", r.latency, r.latencyBuckets)
+
+	writeHeader(&b, "stored_bytes", "histogram", "Size of response bodies stored in the cache by route.")
+	writeHistograms(&b, "stored_bytes", "route", r.stored, r.sizeBuckets)
+	r.mu.Unlock()
+
+	writeHeader(&b, "evictions_total", "counter", "Entries evicted by cache adapters.")
+	fmt.Fprintf(&b, "%s_evictions_total %d\n", namespace, r.evictions.Load())
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func observe(histograms map[string]*histogram, label string, buckets []float64, v float64) {
+	h, ok := histograms[label]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(buckets))}
+		histograms[label] = h
+	}
+	for i, bound := range buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func writeHeader(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s_%s %s\n# TYPE %s_%s %s\n", namespace, name, help, namespace, name, kind)
+}
+
+func writeHistograms(b *strings.Builder, name, labelName string, histograms map[string]*histogram, buckets []float64) {
+	labels := make([]string, 0, len(histograms))
+	for l := range histograms {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+
+	for _, l := range labels {
+		h := histograms[l]
+		label := labelName + "=" + quote(l)
+		for i, bound := range buckets {
+			fmt.Fprintf(b, "%s_%s_bucket{%s,le=%s} %d\n", namespace, name, label, quote(formatFloat(bound)), h.counts[i])
+		}
+		fmt.Fprintf(b, "%s_%s_bucket{%s,le=\"+Inf\"} %d\n", namespace, name, label, h.count)
+		fmt.Fprintf(b, "%s_%s_sum{%s} %s\n", namespace, name, label, formatFloat(h.sum))
+		fmt.Fprintf(b, "%s_%s_count{%s} %d\n", namespace, name, label, h.count)
+	}
+}
+
+// quote returns a label value escaped and quoted as required by the text
+// exposition format.
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedBuckets(buckets []float64) []float64 {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	return sorted
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	cache "github.com/coinpaprika/echo-http-cache"
+	"github.com/coinpaprika/echo-http-cache/adapter/memory"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry(WithSizeBuckets([]float64{4, 1}))
+
+	memoryAdapter, err := memory.NewAdapter(memory.WithOnEvict(registry.Evicted))
+	require.NoError(t, err)
+
+	client, err := cache.NewClient(
+		cache.ClientWithAdapter(memoryAdapter),
+		cache.ClientWithTTL(1*time.Minute),
+		cache.ClientWithRestrictedPaths([]string{"/private"}),
+		cache.ClientWithMetrics(registry),
+	)
+	require.NoError(t, err)
+
+	e := echo.New()
+	api := e.Group("", client.Middleware())
+	api.GET("/coins/:id", func(c echo.Context) error {
+		return c.String(http.StatusOK, c.Param("id"))
+	})
+	api.GET("/private", func(c echo.Context) error {
+		return c.String(http.StatusOK, "private")
+	})
+	e.GET("/metrics", echo.WrapHandler(registry))
+
+	serve := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+	serve("/coins/btc")
+	serve("/coins/btc")
+	serve("/coins/eth")
+	serve("/private")
+	registry.Evicted(1)
+
+	rec := serve("/metrics")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get(echo.HeaderContentType), "text/plain")
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		`# TYPE echo_http_cache_requests_total counter`,
+		`echo_http_cache_requests_total{route="/coins/:id",result="hit"} 1`,
+		`echo_http_cache_requests_total{route="/coins/:id",result="miss"} 2`,
+		`echo_http_cache_requests_total{route="/private",result="bypass"} 1`,
+		`# TYPE echo_http_cache_adapter_duration_seconds histogram`,
+		`echo_http_cache_adapter_duration_seconds_count{operation="get"} 3`,
+		`echo_http_cache_adapter_duration_seconds_bucket{operation="set",le="+Inf"} 2`,
+		`echo_http_cache_stored_bytes_bucket{route="/coins/:id",le="1"} 0`,
+		`echo_http_cache_stored_bytes_bucket{route="/coins/:id",le="4"} 2`,
+		`echo_http_cache_stored_bytes_sum{route="/coins/:id"} 6`,
+		`echo_http_cache_evictions_total 1`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+}
+
+func TestQuote(t *testing.T) {
+	assert.Equal(t, `"a\\b\"c\nd"`, quote("a\\b\"c\nd"))
+}
@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/exp/slices"
+)
+
+// Policy overrides the client caching settings for a route. Zero value
+// fields keep the client settings.
+type Policy struct {
+	// TTL is how long each response is going to be cached.
+	TTL time.Duration
+
+	// StatusCodes lists the cacheable response status codes. By default,
+	// responses with a status code below 400 are cached.
+	StatusCodes []int
+
+	// StatusFunc reports whether a response with the status code may be
+	// cached. It takes precedence over StatusCodes.
+	StatusFunc func(statusCode int) bool
+
+	// NegativeTTL is how long 404 and 410 responses are going to be
+	// cached, if their status code is cacheable. Defaults to TTL.
+	NegativeTTL time.Duration
+
+	// KeyHeaders lists the request headers whose values are part of the
+	// cache key.
+	KeyHeaders []string
+
+	// Disabled turns caching off.
+	Disabled bool
+}
+
+// policy returns the settings applying to a request: the client settings,
+// overridden by the route policy and then by the middleware policy.
+func (client *Client) policy(c echo.Context, override *Policy) Policy {
+	p := Policy{
+		TTL:         client.ttl,
+		StatusCodes: client.statusCodes,
+		StatusFunc:  client.statusFunc,
+		NegativeTTL: client.negativeTTL,
+		KeyHeaders:  client.keyHeaders,
+	}
+	if rp, ok := client.routePolicy(c); ok {
+		p = p.merge(rp)
+	}
+	if override != nil {
+		p = p.merge(*override)
+	}
+	return p
+}
+
+// routePolicy returns the policy registered for the request route path or
+// name. Route names are resolved once per route.
+func (client *Client) routePolicy(c echo.Context) (Policy, bool) {
+	if len(client.policies) == 0 {
+		return Policy{}, false
+	}
+	if p, ok := client.policies[c.Path()]; ok {
+		return p, true
+	}
+
+	route := c.Request().Method + " " + c.Path()
+	if v, ok := client.routePolicies.Load(route); ok {
+		if p := v.(*Policy); p != nil {
+			return *p, true
+		}
+		return Policy{}, false
+	}
+
+	var p *Policy
+	for _, r := range c.Echo().Routes() {
+		if r.Method == c.Request().Method && r.Path == c.Path() {
+			if rp, ok := client.policies[r.Name]; ok {
+				p = &rp
+			}
+			break
+		}
+	}
+	client.routePolicies.Store(route, p)
+
+	if p == nil {
+		return Policy{}, false
+	}
+	return *p, true
+}
+
+// merge returns the policy with the non-zero fields of o applied.
+func (p Policy) merge(o Policy) Policy {
+	if o.TTL > 0 {
+		p.TTL = o.TTL
+	}
+	if o.StatusCodes != nil {
+		p.StatusCodes = o.StatusCodes
+		p.StatusFunc = nil
+	}
+	if o.StatusFunc != nil {
+		p.StatusCodes = nil
+		p.StatusFunc = o.StatusFunc
+	}
+	if o.NegativeTTL > 0 {
+		p.NegativeTTL = o.NegativeTTL
+	}
+	if o.KeyHeaders != nil {
+		p.KeyHeaders = canonicalHeaders(o.KeyHeaders)
+	}
+	if o.Disabled {
+		p.Disabled = true
+	}
+	return p
+}
+
+// cacheableStatus reports whether a response with the status code may be
+// cached.
+func (p Policy) cacheableStatus(statusCode int) bool {
+	if p.StatusFunc != nil {
+		return p.StatusFunc(statusCode)
+	}
+	if len(p.StatusCodes) > 0 {
+		return slices.Contains(p.StatusCodes, statusCode)
+	}
+	return statusCode < 400
+}
+
+// ttl returns how long a response with the status code is cached, unless
+// its headers say otherwise.
+func (p Policy) ttl(statusCode int) time.Duration {
+	if p.NegativeTTL > 0 && (statusCode == http.StatusNotFound || statusCode == http.StatusGone) {
+		return p.NegativeTTL
+	}
+	return p.TTL
+}
+
+func canonicalHeaders(headers []string) []string {
+	canonical := make([]string, len(headers))
+	for i, h := range headers {
+		canonical[i] = http.CanonicalHeaderKey(h)
+	}
+	return canonical
+}
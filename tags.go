@@ -0,0 +1,245 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderCacheTag is the response header handlers can use to tag a cached
+// response, with tags separated by commas.
+const HeaderCacheTag = "Cache-Tag"
+
+const (
+	tagsContextKey = "echo-http-cache.tags"
+	keyIndexMagic  = "EHCI"
+)
+
+// Tag attaches tags to the response of the request being handled, so that
+// it can be invalidated together with all other responses sharing a tag
+// with Client.InvalidateTags.
+func Tag(c echo.Context, tags ...string) {
+	existing, _ := c.Get(tagsContextKey).([]string)
+	c.Set(tagsContextKey, append(existing, tags...))
+}
+
+// InvalidateTags frees all cached responses tagged with any of the given
+// tags.
+func (client *Client) InvalidateTags(ctx context.Context, tags ...string) error {
+	var errs []error
+	for _, tag := range tags {
+		if err := client.releaseIndex(ctx, "tag:"+tag); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// responseTags returns the tags attached to a response with Tag or the
+// Cache-Tag header.
+func responseTags(c echo.Context, header http.Header) []string {
+	tags, _ := c.Get(tagsContextKey).([]string)
+	for _, value := range header.Values(HeaderCacheTag) {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// Indexer is implemented by adapters storing sets of keys apart from their
+// entries, used to find cached responses by tag, path or variant. Adding a
+// key is atomic, so that instances sharing the adapter do not lose each
+// other's updates, and sets are not evicted before their keys expire.
+// Adapters without it store each set in a single entry instead, updated by
+// one instance at a time only.
+type Indexer interface {
+	// AddToIndex adds a key to the named set until an expiration date.
+	// Expired keys are pruned.
+	AddToIndex(ctx context.Context, name string, key uint64, expiration time.Time) error
+
+	// TakeIndex frees the named set and returns its keys not expired yet.
+	TakeIndex(ctx context.Context, name string) ([]uint64, error)
+}
+
+// addToIndex adds a cache key to the named index until the entry expires.
+// Failures are logged.
+func (client *Client) addToIndex(ctx context.Context, name string, key uint64, expiration time.Time) {
+	err := ErrNotSupported
+	if indexer, ok := adapterAs[Indexer](client.adapter); ok {
+		err = indexer.AddToIndex(ctx, name, key, expiration)
+	}
+	if errors.Is(err, ErrNotSupported) {
+		err = client.addToKeyIndex(ctx, name, key, expiration)
+	}
+	if err != nil {
+		client.log().ErrorContext(ctx, "cache index update failed", "index", name, "key", KeyAsString(key), "error", err)
+	}
+}
+
+// releaseIndex frees all cache keys of the named index and the index itself.
+func (client *Client) releaseIndex(ctx context.Context, name string) error {
+	err := ErrNotSupported
+	var keys []uint64
+	if indexer, ok := adapterAs[Indexer](client.adapter); ok {
+		keys, err = indexer.TakeIndex(ctx, name)
+	}
+	if errors.Is(err, ErrNotSupported) {
+		keys, err = client.takeKeyIndex(ctx, name)
+	}
+
+	return errors.Join(err, client.releaseKeys(ctx, keys))
+}
+
+// keyIndexVersion is the version of the key index entry format, following
+// keyIndexMagic. Entries of the previous format, holding the index
+// expiration then keys, have no version.
+const keyIndexVersion = 2
+
+// keyIndex is a set of cache keys stored in an adapter entry by adapters
+// not implementing Indexer.
+type keyIndex struct {
+	keys []indexedKey
+}
+
+// indexedKey is a cache key of a keyIndex with its expiration date.
+type indexedKey struct {
+	key        uint64
+	expiration time.Time
+}
+
+// indexLock returns the lock of the index entry stored under a key.
+func (client *Client) indexLock(indexKey uint64) *sync.Mutex {
+	return &client.indexMu[indexKey%uint64(len(client.indexMu))]
+}
+
+// addToKeyIndex adds a cache key to the named index entry, pruning the
+// expired keys and keeping the entry until its last key expires.
+func (client *Client) addToKeyIndex(ctx context.Context, name string, key uint64, expiration time.Time) error {
+	indexKey := generateKey(keyIndexMagic + name)
+	mu := client.indexLock(indexKey)
+	mu.Lock()
+	defer mu.Unlock()
+
+	var index keyIndex
+	if b, ok := client.get(ctx, indexKey); ok {
+		index, _ = parseKeyIndex(b)
+	}
+	index.add(key, expiration, time.Now())
+	if len(index.keys) == 0 {
+		return nil
+	}
+	return client.adapter.Set(ctx, indexKey, index.bytes(), index.expiration())
+}
+
+// takeKeyIndex frees the named index entry and returns its keys not
+// expired yet.
+func (client *Client) takeKeyIndex(ctx context.Context, name string) ([]uint64, error) {
+	indexKey := generateKey(keyIndexMagic + name)
+	mu := client.indexLock(indexKey)
+	mu.Lock()
+	defer mu.Unlock()
+
+	b, err := client.adapter.Get(ctx, indexKey)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.adapter.Delete(ctx, indexKey)
+	client.publish(ctx, indexKey)
+
+	index, _ := parseKeyIndex(b)
+	now := time.Now()
+	keys := make([]uint64, 0, len(index.keys))
+	for _, k := range index.keys {
+		if k.expiration.After(now) {
+			keys = append(keys, k.key)
+		}
+	}
+	return keys, err
+}
+
+// add adds a key to the index, or extends its expiration, and prunes the
+// expired keys.
+func (i *keyIndex) add(key uint64, expiration, now time.Time) {
+	keys := i.keys[:0]
+	found := false
+	for _, k := range i.keys {
+		if k.key == key {
+			found = true
+			if expiration.After(k.expiration) {
+				k.expiration = expiration
+			}
+		}
+		if k.expiration.After(now) {
+			keys = append(keys, k)
+		}
+	}
+	if !found && expiration.After(now) {
+		keys = append(keys, indexedKey{key: key, expiration: expiration})
+	}
+	i.keys = keys
+}
+
+// expiration returns the expiration date of the last key of the index.
+func (i keyIndex) expiration() time.Time {
+	var expiration time.Time
+	for _, k := range i.keys {
+		if k.expiration.After(expiration) {
+			expiration = k.expiration
+		}
+	}
+	return expiration
+}
+
+func (i keyIndex) bytes() []byte {
+	b := make([]byte, 0, len(keyIndexMagic)+1+16*len(i.keys))
+	b = append(b, keyIndexMagic...)
+	b = append(b, keyIndexVersion)
+	for _, k := range i.keys {
+		b = binary.BigEndian.AppendUint64(b, k.key)
+		b = binary.BigEndian.AppendUint64(b, uint64(k.expiration.UnixNano()))
+	}
+	return b
+}
+
+func parseKeyIndex(b []byte) (keyIndex, bool) {
+	if !bytes.HasPrefix(b, []byte(keyIndexMagic)) {
+		return keyIndex{}, false
+	}
+	b = b[len(keyIndexMagic):]
+
+	if len(b)%16 == 1 && b[0] == keyIndexVersion {
+		index := keyIndex{keys: make([]indexedKey, 0, len(b)/16)}
+		for b = b[1:]; len(b) > 0; b = b[16:] {
+			index.keys = append(index.keys, indexedKey{
+				key:        binary.BigEndian.Uint64(b),
+				expiration: time.Unix(0, int64(binary.BigEndian.Uint64(b[8:]))),
+			})
+		}
+		return index, true
+	}
+
+	// previous format, all keys expiring with the index
+	if len(b) == 0 || len(b)%8 != 0 {
+		return keyIndex{}, false
+	}
+	expiration := time.Unix(0, int64(binary.BigEndian.Uint64(b)))
+	index := keyIndex{keys: make([]indexedKey, 0, len(b)/8-1)}
+	for b = b[8:]; len(b) > 0; b = b[8:] {
+		index.keys = append(index.keys, indexedKey{key: binary.BigEndian.Uint64(b), expiration: expiration})
+	}
+	return index, true
+}
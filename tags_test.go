@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coinpaprika/echo-http-cache/adapter/memory"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvalidateTags(t *testing.T) {
+	indexer, err := memory.NewAdapter()
+	require.NoError(t, err)
+
+	adapters := []struct {
+		name    string
+		adapter Adapter
+	}{
+		{"index entries", &adapterMock{store: map[uint64][]byte{}}},
+		{"indexer", indexer},
+	}
+	for _, a := range adapters {
+		t.Run(a.name, func(t *testing.T) {
+			testInvalidateTags(t, a.adapter)
+		})
+	}
+}
+
+func testInvalidateTags(t *testing.T, adapter Adapter) {
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+	)
+	require.NoError(t, err)
+
+	calls := 0
+	e := echo.New()
+	e.Use(client.Middleware())
+	e.GET("/coins/:id", func(c echo.Context) error {
+		calls++
+		Tag(c, "coin:"+c.Param("id"))
+		return c.String(http.StatusOK, c.Param("id"))
+	})
+	e.GET("/tickers/:id", func(c echo.Context) error {
+		calls++
+		c.Response().Header().Set(HeaderCacheTag, "ticker, coin:"+c.Param("id"))
+		return c.String(http.StatusOK, c.Param("id"))
+	})
+
+	serve := func(url string) {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+	}
+	urls := []string{"/coins/btc", "/coins/eth", "/tickers/btc", "/tickers/eth"}
+	for _, url := range urls {
+		serve(url)
+	}
+	require.Equal(t, 4, calls)
+
+	tests := []struct {
+		name      string
+		tags      []string
+		wantCalls int
+	}{
+		{"invalidates nothing", []string{"unknown"}, 4},
+		{"invalidates tag set with helper and header", []string{"coin:btc"}, 6},
+		{"invalidates tag set with header", []string{"ticker"}, 8},
+		{"invalidates multiple tags", []string{"coin:btc", "coin:eth"}, 12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, client.InvalidateTags(context.Background(), tt.tags...))
+			for _, url := range urls {
+				serve(url)
+			}
+			assert.Equal(t, tt.wantCalls, calls)
+		})
+	}
+}
+
+func TestKeyIndex(t *testing.T) {
+	now := time.Unix(0, 1700000000000000000)
+	var index keyIndex
+	index.add(1, now.Add(1*time.Minute), now)
+	index.add(2, now.Add(1*time.Second), now)
+	index.add(18446744073709551615, now.Add(1*time.Minute), now)
+	index.add(1, now.Add(2*time.Minute), now)
+	index.add(3, now.Add(-1*time.Second), now)
+
+	got, ok := parseKeyIndex(index.bytes())
+	require.True(t, ok)
+	require.Len(t, got.keys, 3)
+	assert.Equal(t, uint64(1), got.keys[0].key)
+	assert.True(t, now.Add(2*time.Minute).Equal(got.keys[0].expiration))
+	assert.True(t, now.Add(2*time.Minute).Equal(got.expiration()))
+
+	// expired keys are pruned
+	got.add(4, now.Add(1*time.Minute), now.Add(30*time.Second))
+	assert.Equal(t, []uint64{1, 18446744073709551615, 4}, []uint64{got.keys[0].key, got.keys[1].key, got.keys[2].key})
+
+	// previous format
+	legacy := binary.BigEndian.AppendUint64([]byte(keyIndexMagic), uint64(now.UnixNano()))
+	legacy = binary.BigEndian.AppendUint64(legacy, 5)
+	got, ok = parseKeyIndex(legacy)
+	require.True(t, ok)
+	require.Len(t, got.keys, 1)
+	assert.Equal(t, uint64(5), got.keys[0].key)
+	assert.True(t, now.Equal(got.keys[0].expiration))
+
+	_, ok = parseKeyIndex(Response{Value: []byte("value")}.Bytes())
+	assert.False(t, ok)
+}
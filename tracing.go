@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Span attribute keys set by the middleware client.
+const (
+	AttributeKey       = "cache.key"
+	AttributeHit       = "cache.hit"
+	AttributeHits      = "cache.hits"
+	AttributeSize      = "cache.size"
+	AttributeAdapter   = "cache.adapter"
+	AttributeOperation = "cache.operation"
+)
+
+// Tracer starts spans around cache operations: key generation, adapter
+// operations and background refreshes. It is modeled on the OpenTelemetry
+// tracer so that it can be implemented on top of one.
+type Tracer interface {
+	// Start starts a span as a child of the span in ctx, if any, and
+	// returns a context holding the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is an operation traced by a Tracer.
+type Span interface {
+	// SetAttributes sets attributes describing the operation.
+	SetAttributes(attributes ...Attribute)
+
+	// RecordError records an error the operation failed with.
+	RecordError(err error)
+
+	// End completes the span.
+	End()
+}
+
+// Attribute is a key-value pair describing a traced operation.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// ClientWithTracer sets the tracer starting spans around the middleware
+// client cache operations.
+func ClientWithTracer(t Tracer) ClientOption {
+	return func(c *Client) error {
+		c.tracer = t
+		return nil
+	}
+}
+
+// startSpan starts a span with the client tracer, if any.
+func (client *Client) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if client.tracer == nil {
+		return ctx, noopSpan{}
+	}
+	return client.tracer.Start(ctx, name)
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...Attribute) {}
+func (noopSpan) RecordError(error)          {}
+func (noopSpan) End()                       {}
+
+// tracedAdapter starts a span around each operation of an adapter.
+type tracedAdapter struct {
+	adapter     AdapterV2
+	tracer      Tracer
+	adapterType string
+}
+
+func newTracedAdapter(a AdapterV2, t Tracer) *tracedAdapter {
+	return &tracedAdapter{adapter: a, tracer: t, adapterType: adapterType(a)}
+}
+
+// Unwrap returns the traced adapter.
+func (a *tracedAdapter) Unwrap() AdapterV2 {
+	return a.adapter
+}
+
+func (a *tracedAdapter) start(ctx context.Context, operation string, attributes ...Attribute) (context.Context, Span) {
+	ctx, span := a.tracer.Start(ctx, "cache.adapter."+operation)
+	span.SetAttributes(append([]Attribute{
+		{Key: AttributeOperation, Value: operation},
+		{Key: AttributeAdapter, Value: a.adapterType},
+	}, attributes...)...)
+	return ctx, span
+}
+
+func (a *tracedAdapter) end(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+func (a *tracedAdapter) Get(ctx context.Context, key uint64) ([]byte, error) {
+	ctx, span := a.start(ctx, "get", Attribute{Key: AttributeKey, Value: KeyAsString(key)})
+	b, err := a.adapter.Get(ctx, key)
+	span.SetAttributes(Attribute{Key: AttributeHit, Value: err == nil}, Attribute{Key: AttributeSize, Value: len(b)})
+	if errors.Is(err, ErrNotFound) {
+		// a miss is not a failure
+		a.end(span, nil)
+		return nil, err
+	}
+	a.end(span, err)
+	return b, err
+}
+
+func (a *tracedAdapter) GetMulti(ctx context.Context, keys []uint64) (map[uint64][]byte, error) {
+	ctx, span := a.start(ctx, "get_multi")
+	responses, err := a.adapter.GetMulti(ctx, keys)
+	size := 0
+	for _, b := range responses {
+		size += len(b)
+	}
+	span.SetAttributes(Attribute{Key: AttributeHits, Value: len(responses)}, Attribute{Key: AttributeSize, Value: size})
+	a.end(span, err)
+	return responses, err
+}
+
+func (a *tracedAdapter) Set(ctx context.Context, key uint64, response []byte, expiration time.Time) error {
+	ctx, span := a.start(ctx, "set", Attribute{Key: AttributeKey, Value: KeyAsString(key)}, Attribute{Key: AttributeSize, Value: len(response)})
+	err := a.adapter.Set(ctx, key, response, expiration)
+	a.end(span, err)
+	return err
+}
+
+func (a *tracedAdapter) SetMulti(ctx context.Context, entries []Entry) error {
+	size := 0
+	for _, entry := range entries {
+		size += len(entry.Value)
+	}
+	ctx, span := a.start(ctx, "set_multi", Attribute{Key: AttributeSize, Value: size})
+	err := a.adapter.SetMulti(ctx, entries)
+	a.end(span, err)
+	return err
+}
+
+func (a *tracedAdapter) Delete(ctx context.Context, key uint64) error {
+	ctx, span := a.start(ctx, "delete", Attribute{Key: AttributeKey, Value: KeyAsString(key)})
+	err := a.adapter.Delete(ctx, key)
+	a.end(span, err)
+	return err
+}
+
+func (a *tracedAdapter) Clear(ctx context.Context) error {
+	ctx, span := a.start(ctx, "clear")
+	err := a.adapter.Clear(ctx)
+	a.end(span, err)
+	return err
+}
+
+// adapterType returns the type name of the adapter, or of the adapter it
+// wraps.
+func adapterType(a AdapterV2) string {
+	switch w := a.(type) {
+	case interface{ Unwrap() AdapterV2 }:
+		return adapterType(w.Unwrap())
+	case interface{ Unwrap() Adapter }:
+		return fmt.Sprintf("%T", w.Unwrap())
+	}
+	return fmt.Sprintf("%T", a)
+}
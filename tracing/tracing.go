@@ -0,0 +1,116 @@
+// Package tracing implements an in-memory cache Tracer recording spans, to
+// inspect the cache operations in tests.
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	cache "github.com/coinpaprika/echo-http-cache"
+)
+
+type spanContextKey struct{}
+
+type (
+	// Recorder is a cache.Tracer keeping the spans it starts in memory.
+	Recorder struct {
+		mu    sync.Mutex
+		spans []*RecordedSpan
+	}
+
+	// RecordedSpan is a span started by a Recorder.
+	RecordedSpan struct {
+		ID         int
+		ParentID   int
+		Name       string
+		Attributes map[string]any
+		Errors     []error
+		StartTime  time.Time
+		EndTime    time.Time
+
+		recorder *Recorder
+	}
+)
+
+// NewRecorder initializes a span recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Start implements the cache Tracer interface Start method.
+func (r *Recorder) Start(ctx context.Context, name string) (context.Context, cache.Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	span := &RecordedSpan{
+		ID:         len(r.spans) + 1,
+		Name:       name,
+		Attributes: map[string]any{},
+		StartTime:  time.Now(),
+		recorder:   r,
+	}
+	if parent, ok := ctx.Value(spanContextKey{}).(*RecordedSpan); ok {
+		span.ParentID = parent.ID
+	}
+	r.spans = append(r.spans, span)
+
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+// Spans returns a copy of the ended spans, in the order they were started.
+func (r *Recorder) Spans() []RecordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	spans := make([]RecordedSpan, 0, len(r.spans))
+	for _, span := range r.spans {
+		if span.EndTime.IsZero() {
+			continue
+		}
+		s := *span
+		s.Attributes = make(map[string]any, len(span.Attributes))
+		for k, v := range span.Attributes {
+			s.Attributes[k] = v
+		}
+		s.Errors = append([]error(nil), span.Errors...)
+		spans = append(spans, s)
+	}
+	return spans
+}
+
+// Reset forgets all recorded spans.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.spans = nil
+}
+
+// SetAttributes implements the cache Span interface SetAttributes method.
+func (s *RecordedSpan) SetAttributes(attributes ...cache.Attribute) {
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+
+	for _, a := range attributes {
+		s.Attributes[a.Key] = a.Value
+	}
+}
+
+// RecordError implements the cache Span interface RecordError method.
+func (s *RecordedSpan) RecordError(err error) {
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+
+	s.Errors = append(s.Errors, err)
+}
+
+// End implements the cache Span interface End method.
+func (s *RecordedSpan) End() {
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+
+	if s.EndTime.IsZero() {
+		s.EndTime = time.Now()
+	}
+}
@@ -0,0 +1,98 @@
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	cache "github.com/coinpaprika/echo-http-cache"
+	"github.com/coinpaprika/echo-http-cache/adapter/memory"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	recorder := NewRecorder()
+
+	memoryAdapter, err := memory.NewAdapter()
+	require.NoError(t, err)
+
+	client, err := cache.NewClient(
+		cache.ClientWithAdapter(memoryAdapter),
+		cache.ClientWithTTL(50*time.Millisecond),
+		cache.ClientWithStaleWhileRevalidate(1*time.Minute),
+		cache.ClientWithTracer(recorder),
+	)
+	require.NoError(t, err)
+
+	e := echo.New()
+	e.Use(client.Middleware())
+	e.GET("/coins/:id", func(c echo.Context) error {
+		return c.String(http.StatusOK, c.Param("id"))
+	})
+
+	serve := func() {
+		ctx, span := recorder.Start(t.Context(), "request")
+		defer span.End()
+		req := httptest.NewRequest(http.MethodGet, "/coins/btc", nil).WithContext(ctx)
+		e.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	names := func(spans []RecordedSpan) []string {
+		var names []string
+		for _, span := range spans {
+			names = append(names, span.Name)
+		}
+		return names
+	}
+
+	t.Run("miss", func(t *testing.T) {
+		recorder.Reset()
+		serve()
+
+		spans := recorder.Spans()
+		require.Equal(t, []string{"request", "cache.key", "cache.adapter.get", "cache.adapter.set"}, names(spans))
+		for _, span := range spans[1:] {
+			assert.Equal(t, spans[0].ID, span.ParentID)
+		}
+
+		key := spans[1].Attributes[cache.AttributeKey]
+		assert.NotEmpty(t, key)
+		assert.Equal(t, map[string]any{
+			cache.AttributeOperation: "get",
+			cache.AttributeAdapter:   "*memory.Adapter",
+			cache.AttributeKey:       key,
+			cache.AttributeHit:       false,
+			cache.AttributeSize:      0,
+		}, spans[2].Attributes)
+		assert.Empty(t, spans[2].Errors)
+		assert.Equal(t, key, spans[3].Attributes[cache.AttributeKey])
+		assert.Positive(t, spans[3].Attributes[cache.AttributeSize])
+	})
+
+	t.Run("hit", func(t *testing.T) {
+		recorder.Reset()
+		serve()
+
+		spans := recorder.Spans()
+		require.Equal(t, []string{"request", "cache.key", "cache.adapter.get"}, names(spans))
+		assert.Equal(t, true, spans[2].Attributes[cache.AttributeHit])
+		assert.Positive(t, spans[2].Attributes[cache.AttributeSize])
+	})
+
+	t.Run("background refresh", func(t *testing.T) {
+		time.Sleep(60 * time.Millisecond)
+		recorder.Reset()
+		serve()
+
+		assert.Eventually(t, func() bool {
+			for _, span := range recorder.Spans() {
+				if span.Name == "cache.revalidate" {
+					return span.ParentID == 1 && span.Attributes[cache.AttributeSize] == 3
+				}
+			}
+			return false
+		}, time.Second, 10*time.Millisecond)
+	})
+}
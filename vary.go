@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/exp/slices"
+)
+
+// varyHeaders returns the sorted, canonical request header names listed in
+// the response Vary header. It returns false when the response varies on
+// "*" and so cannot be cached.
+func varyHeaders(header http.Header) ([]string, bool) {
+	var vary []string
+	for _, value := range header.Values(echo.HeaderVary) {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if name == "*" {
+				return nil, false
+			}
+			name = http.CanonicalHeaderKey(name)
+			if !slices.Contains(vary, name) {
+				vary = append(vary, name)
+			}
+		}
+	}
+	sort.Strings(vary)
+
+	return vary, true
+}
+
+// variantKey derives the key a response variant is stored under from the
+// request key and the request values of the headers the response varies on.
+func variantKey(key uint64, vary []string, header http.Header) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(strconv.FormatUint(key, 10)))
+	for _, name := range vary {
+		hash.Write([]byte("\n" + name + ":" + strings.Join(header.Values(name), ",")))
+	}
+
+	return hash.Sum64()
+}